@@ -0,0 +1,146 @@
+package stats
+
+import (
+	"sync"
+	"time"
+)
+
+// CardinalityLimitedMetric is the name of the counter reported by
+// CardinalityLimiter when a measure exceeds the cardinality limit of its name.
+// The counter is tagged with the name of the limited measure.
+const CardinalityLimitedMetric = "stats.cardinality_limited"
+
+// CardinalityLimiter is a handler which protects metric backends against tag
+// explosions, for example when a request ID is mistakenly used as a tag value.
+//
+// The limiter tracks the distinct sets of tags seen for each measure name, and
+// once a name reaches its limit, the measures carrying new sets of tags are
+// either dropped, or have all their tag values replaced by "other". Each
+// limited measure increments the CardinalityLimitedMetric counter.
+type CardinalityLimiter struct {
+	// Handler receives the measures that passed the limiter, and the
+	// CardinalityLimitedMetric counters.
+	//
+	// This field cannot be nil.
+	Handler Handler
+
+	// Limit is the maximum number of distinct sets of tags of each measure
+	// name, zero means no limit.
+	Limit int
+
+	// Limits overrides Limit for specific measure names.
+	Limits map[string]int
+
+	// When true, the tag values of measures exceeding the limit are replaced
+	// by "other" instead of dropping the measures.
+	Collapse bool
+
+	mutex   sync.Mutex
+	tagSets map[string]map[string]struct{}
+}
+
+// HandleMeasures satisfies the Handler interface.
+func (c *CardinalityLimiter) HandleMeasures(time time.Time, measures ...Measure) {
+	var limited []Measure // allocated when the first measure is limited
+	var reports []Measure
+
+	for i, m := range measures {
+		if c.allow(m) {
+			if limited != nil {
+				limited = append(limited, m)
+			}
+			continue
+		}
+
+		if limited == nil {
+			limited = append(make([]Measure, 0, len(measures)), measures[:i]...)
+		}
+
+		if c.Collapse {
+			limited = append(limited, collapseTags(m))
+		}
+
+		reports = append(reports, Measure{
+			Name:   CardinalityLimitedMetric,
+			Fields: []Field{MakeField("", 1, Counter)},
+			Tags:   []Tag{T("metric", m.Name)},
+		})
+	}
+
+	if limited != nil {
+		measures = append(limited, reports...)
+	}
+
+	if len(measures) != 0 {
+		c.Handler.HandleMeasures(time, measures...)
+	}
+}
+
+// Flush satisfies the Flusher interface.
+func (c *CardinalityLimiter) Flush() {
+	flush(c.Handler)
+}
+
+// allow returns true if m is within the cardinality limit of its name, in
+// which case its set of tags is recorded.
+func (c *CardinalityLimiter) allow(m Measure) bool {
+	limit, ok := c.Limits[m.Name]
+	if !ok {
+		limit = c.Limit
+	}
+
+	if limit <= 0 || len(m.Tags) == 0 {
+		return true
+	}
+
+	key := tagSetKey(m.Tags)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	if c.tagSets == nil {
+		c.tagSets = make(map[string]map[string]struct{})
+	}
+
+	tagSets := c.tagSets[m.Name]
+	if tagSets == nil {
+		tagSets = make(map[string]struct{})
+		c.tagSets[m.Name] = tagSets
+	}
+
+	if _, seen := tagSets[key]; seen {
+		return true
+	}
+
+	if len(tagSets) >= limit {
+		return false
+	}
+
+	tagSets[key] = struct{}{}
+	return true
+}
+
+func tagSetKey(tags []Tag) string {
+	n := 0
+	for _, t := range tags {
+		n += len(t.Name) + len(t.Value) + 2
+	}
+
+	b := make([]byte, 0, n)
+	for _, t := range tags {
+		b = append(b, t.Name...)
+		b = append(b, 0)
+		b = append(b, t.Value...)
+		b = append(b, 0)
+	}
+	return string(b)
+}
+
+func collapseTags(m Measure) Measure {
+	tags := make([]Tag, len(m.Tags))
+	for i, t := range m.Tags {
+		tags[i] = T(t.Name, "other")
+	}
+	m.Tags = tags
+	return m
+}
@@ -0,0 +1,75 @@
+package stats_test
+
+import (
+	"reflect"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/statstest"
+)
+
+func TestCardinalityLimiter(t *testing.T) {
+	counter := func(name string, tags ...stats.Tag) stats.Measure {
+		return stats.Measure{
+			Name:   name,
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   tags,
+		}
+	}
+
+	limited := counter(stats.CardinalityLimitedMetric, stats.T("metric", "request"))
+
+	tests := []struct {
+		scenario string
+		collapse bool
+		expect   []stats.Measure
+	}{
+		{
+			scenario: "measures with new tag sets beyond the limit are dropped",
+			expect: []stats.Measure{
+				counter("request", stats.T("id", "0")),
+				counter("request", stats.T("id", "1")),
+				counter("request", stats.T("id", "0")),
+				limited,
+				counter("other"),
+			},
+		},
+		{
+			scenario: "measures with new tag sets beyond the limit are collapsed",
+			collapse: true,
+			expect: []stats.Measure{
+				counter("request", stats.T("id", "0")),
+				counter("request", stats.T("id", "1")),
+				counter("request", stats.T("id", "0")),
+				counter("request", stats.T("id", "other")),
+				limited,
+				counter("other"),
+			},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			h := &statstest.Handler{}
+			c := &stats.CardinalityLimiter{
+				Handler:  h,
+				Limits:   map[string]int{"request": 2},
+				Collapse: test.collapse,
+			}
+
+			now := time.Now()
+			for _, id := range []int{0, 1, 0, 2} {
+				c.HandleMeasures(now, counter("request", stats.T("id", strconv.Itoa(id))))
+			}
+			c.HandleMeasures(now, counter("other"))
+
+			if found := h.Measures(); !reflect.DeepEqual(found, test.expect) {
+				t.Error("bad measures:")
+				t.Logf("expected: %v", test.expect)
+				t.Logf("found:    %v", found)
+			}
+		})
+	}
+}
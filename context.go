@@ -0,0 +1,99 @@
+package stats
+
+import (
+	"context"
+	"time"
+)
+
+type contextTagsKey struct{}
+
+// ContextWithTags returns a copy of ctx carrying tags, in addition to the tags
+// that ctx already carried.
+//
+// The tags are set on every measure produced by the *Context methods of
+// engines with the returned context, which makes it possible to attach
+// request-scoped tags, like a tenant or a shard, to all metrics recorded while
+// serving a request without passing them down explicitly.
+func ContextWithTags(ctx context.Context, tags ...Tag) context.Context {
+	if len(tags) == 0 {
+		return ctx
+	}
+	parent := TagsFromContext(ctx)
+	merged := make([]Tag, 0, len(parent)+len(tags))
+	merged = append(merged, parent...)
+	merged = append(merged, tags...)
+	return context.WithValue(ctx, contextTagsKey{}, merged)
+}
+
+// TagsFromContext returns the tags carried by ctx, the returned slice must be
+// treated as read-only.
+func TagsFromContext(ctx context.Context) []Tag {
+	tags, _ := ctx.Value(contextTagsKey{}).([]Tag)
+	return tags
+}
+
+// IncrContext increments by one the counter identified by name, tags, and the
+// tags carried by ctx.
+func (eng *Engine) IncrContext(ctx context.Context, name string, tags ...Tag) {
+	eng.measureWith(time.Now(), name, 1, Counter, TagsFromContext(ctx), tags)
+}
+
+// AddContext increments by value the counter identified by name, tags, and the
+// tags carried by ctx.
+func (eng *Engine) AddContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	eng.measureWith(time.Now(), name, value, Counter, TagsFromContext(ctx), tags)
+}
+
+// SetContext sets to value the gauge identified by name, tags, and the tags
+// carried by ctx.
+func (eng *Engine) SetContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	eng.measureWith(time.Now(), name, value, Gauge, TagsFromContext(ctx), tags)
+}
+
+// ObserveContext reports value for the histogram identified by name, tags, and
+// the tags carried by ctx.
+func (eng *Engine) ObserveContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	eng.measureWith(time.Now(), name, value, Histogram, TagsFromContext(ctx), tags)
+}
+
+// DistributionContext reports value for the distribution identified by name,
+// tags, and the tags carried by ctx.
+func (eng *Engine) DistributionContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	eng.measureWith(time.Now(), name, value, Distribution, TagsFromContext(ctx), tags)
+}
+
+// UniqueContext reports value for the set identified by name, tags, and the
+// tags carried by ctx.
+func (eng *Engine) UniqueContext(ctx context.Context, name string, value int64, tags ...Tag) {
+	eng.measureWith(time.Now(), name, value, SetType, TagsFromContext(ctx), tags)
+}
+
+// IncrContext increments by one the counter identified by name, tags, and the
+// tags carried by ctx.
+func IncrContext(ctx context.Context, name string, tags ...Tag) {
+	DefaultEngine.IncrContext(ctx, name, tags...)
+}
+
+// AddContext increments by value the counter identified by name, tags, and the
+// tags carried by ctx.
+func AddContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	DefaultEngine.AddContext(ctx, name, value, tags...)
+}
+
+// SetContext sets to value the gauge identified by name, tags, and the tags
+// carried by ctx.
+func SetContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	DefaultEngine.SetContext(ctx, name, value, tags...)
+}
+
+// ObserveContext reports value for the histogram identified by name, tags, and
+// the tags carried by ctx.
+func ObserveContext(ctx context.Context, name string, value interface{}, tags ...Tag) {
+	DefaultEngine.ObserveContext(ctx, name, value, tags...)
+}
+
+// UniqueContext reports value for the set identified by name, tags, and the
+// tags carried by ctx.
+func UniqueContext(ctx context.Context, name string, value int64, tags ...Tag) {
+	DefaultEngine.UniqueContext(ctx, name, value, tags...)
+}
@@ -0,0 +1,52 @@
+package stats_test
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/statstest"
+)
+
+func TestContextWithTags(t *testing.T) {
+	ctx := context.Background()
+
+	if tags := stats.TagsFromContext(ctx); tags != nil {
+		t.Errorf("unexpected tags in an empty context: %v", tags)
+	}
+
+	parent := stats.ContextWithTags(ctx, stats.T("tenant", "acme"))
+	child := stats.ContextWithTags(parent, stats.T("shard", "7"))
+
+	if tags := stats.TagsFromContext(parent); !reflect.DeepEqual(tags, []stats.Tag{stats.T("tenant", "acme")}) {
+		t.Errorf("bad parent tags: %v", tags)
+	}
+
+	if tags := stats.TagsFromContext(child); !reflect.DeepEqual(tags, []stats.Tag{stats.T("tenant", "acme"), stats.T("shard", "7")}) {
+		t.Errorf("bad child tags: %v", tags)
+	}
+}
+
+func TestEngineContext(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("test", h, stats.T("service", "test-service"))
+
+	ctx := stats.ContextWithTags(context.Background(), stats.T("tenant", "acme"))
+
+	eng.IncrContext(ctx, "measure.count", stats.T("type", "testing"))
+	eng.ObserveContext(ctx, "measure.size", 42)
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test.measure.count",
+			Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("tenant", "acme"), stats.T("type", "testing")},
+		},
+		stats.Measure{
+			Name:   "test.measure.size",
+			Fields: []stats.Field{stats.MakeField("", 42, stats.Histogram)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("tenant", "acme")},
+		},
+	)
+}
@@ -0,0 +1,64 @@
+package stats
+
+import (
+	"encoding/csv"
+	"io"
+	"strconv"
+	"strings"
+)
+
+// WriteCSV writes a CSV representation of measures to w, which is intended to
+// be used for ad-hoc analysis of metrics in spreadsheets.
+//
+// The output starts with a header row, followed by one row for each field of
+// the measures with columns for the metric name (the measure and field names
+// joined by a dot), the field type, the value, the sample rate, and the tags.
+// Durations are represented in seconds, the sample rate is 1 for measures that
+// were not sampled, and tags are serialized as a sorted list of name=value
+// pairs separated by commas.
+func WriteCSV(w io.Writer, measures []Measure) error {
+	cw := csv.NewWriter(w)
+
+	if err := cw.Write([]string{"name", "type", "value", "sample", "tags"}); err != nil {
+		return err
+	}
+
+	row := make([]string, 5)
+
+	for _, m := range measures {
+		tags := copyTags(m.Tags)
+
+		if !TagsAreSorted(tags) {
+			SortTags(tags)
+		}
+
+		row[3] = "1"
+		if rate := m.SampleRate; rate > 0 && rate < 1 {
+			row[3] = strconv.FormatFloat(rate, 'g', -1, 64)
+		}
+
+		row[4] = strings.Join(stringTags(tags), ",")
+
+		for _, f := range m.Fields {
+			row[0] = m.Name
+			if len(f.Name) != 0 {
+				row[0] += "." + f.Name
+			}
+
+			row[1] = f.Type().String()
+
+			if f.Value.Type() == Duration {
+				row[2] = strconv.FormatFloat(f.Value.Duration().Seconds(), 'g', -1, 64)
+			} else {
+				row[2] = f.Value.String()
+			}
+
+			if err := cw.Write(row); err != nil {
+				return err
+			}
+		}
+	}
+
+	cw.Flush()
+	return cw.Error()
+}
@@ -0,0 +1,52 @@
+package stats
+
+import (
+	"bytes"
+	"testing"
+	"time"
+)
+
+func TestWriteCSV(t *testing.T) {
+	measures := []Measure{
+		{
+			Name: "request",
+			Fields: []Field{
+				MakeField("count", 1, Counter),
+				MakeField("rtt", 1500*time.Millisecond, Histogram),
+			},
+			Tags: []Tag{T("method", "GET"), T("host", "localhost")},
+		},
+		{
+			Name:   "queue.size",
+			Fields: []Field{MakeField("", 42.5, Gauge)},
+		},
+		{
+			Name:       "cache",
+			Fields:     []Field{MakeField("hits", 3, Counter)},
+			SampleRate: 0.25,
+		},
+	}
+
+	b := &bytes.Buffer{}
+
+	if err := WriteCSV(b, measures); err != nil {
+		t.Fatal(err)
+	}
+
+	const expect = `name,type,value,sample,tags
+request.count,counter,1,1,"host=localhost,method=GET"
+request.rtt,histogram,1.5,1,"host=localhost,method=GET"
+queue.size,gauge,42.5,1,
+cache.hits,counter,3,0.25,
+`
+
+	if s := b.String(); s != expect {
+		t.Error("bad CSV output:")
+		t.Log("expected:", expect)
+		t.Log("found:   ", s)
+	}
+
+	if measures[0].Tags[0].Name != "method" {
+		t.Error("WriteCSV modified the tags of the measures")
+	}
+}
@@ -0,0 +1,533 @@
+package datadog
+
+import (
+	"bytes"
+	"compress/gzip"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"net/url"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/segmentio/objconv/json"
+	"github.com/segmentio/stats"
+)
+
+const (
+	// DefaultSite is the default datadog site that API clients submit metrics
+	// to.
+	DefaultSite = "datadoghq.com"
+
+	// DefaultAPIFlushInterval is the default interval at which API clients
+	// submit the series they accumulated.
+	DefaultAPIFlushInterval = 10 * time.Second
+
+	// DefaultAPITimeout is the default timeout of requests sent by API clients.
+	DefaultAPITimeout = 10 * time.Second
+
+	// DefaultAPIMaxRetries is the default number of times that API clients
+	// retry a failed submission.
+	DefaultAPIMaxRetries = 3
+)
+
+const (
+	// Bounds of the exponential backoff between retries of failed
+	// submissions, when the API didn't request a delay.
+	minRetryDelay = 1 * time.Second
+	maxRetryDelay = 30 * time.Second
+)
+
+// The APIClientConfig type is used to configure API clients.
+type APIClientConfig struct {
+	// APIKey is the datadog API key used to authenticate submissions.
+	APIKey string
+
+	// Site is the datadog site that metrics are submitted to, for example
+	// datadoghq.eu, the default is DefaultSite.
+	Site string
+
+	// Endpoint overrides the base URL of the API derived from the Site.
+	Endpoint string
+
+	// ProxyURL is the URL of the HTTP proxy used to reach the API. When empty,
+	// the proxy configured by the environment is used.
+	ProxyURL string
+
+	// Transport configures the HTTP transport used to send requests, ProxyURL
+	// is ignored when it is set.
+	Transport http.RoundTripper
+
+	// Maximum amount of time that requests to the API may take, the default
+	// is DefaultAPITimeout.
+	Timeout time.Duration
+
+	// FlushInterval is the interval at which accumulated series are
+	// submitted, the default is DefaultAPIFlushInterval. Negative values
+	// disable the periodic submission, the series are then only submitted
+	// when the client is flushed.
+	FlushInterval time.Duration
+
+	// MaxRetries is the number of times that a failed submission is retried
+	// before its series are dropped, the default is DefaultAPIMaxRetries.
+	// Negative values disable retries.
+	MaxRetries int
+
+	// Tags added to all series, unless they already have a tag of the same
+	// name.
+	Tags []stats.Tag
+
+	// List of tags to filter. If left nil is set to DefaultFilters.
+	Filters []string
+
+	// ClockSkew is added to the timestamps of all measures before they are
+	// submitted, it may be used to correct a known offset between the clock
+	// of the host and the actual time.
+	ClockSkew time.Duration
+
+	// ErrorHandler is called with the errors that prevent series from being
+	// submitted. When nil, errors are logged.
+	ErrorHandler func(error)
+}
+
+// APIClient represents a client that submits metrics directly to the datadog
+// API, for environments where no agent is available. It implements the
+// stats.Handler interface.
+//
+// Unlike Client, the API client aggregates measures between flushes: counters
+// are summed, gauges keep their last value, and histograms are submitted as
+// their .count, .avg, .min, and .max series. The host of series is taken from
+// the "host" tag of measures when they have one.
+type APIClient struct {
+	url          string
+	apiKey       string
+	http         http.Client
+	maxRetries   int
+	tags         []stats.Tag
+	filters      map[string]struct{}
+	clockSkew    time.Duration
+	errorHandler func(error)
+
+	mutex  sync.Mutex
+	series map[string]*apiSeriesState
+
+	once sync.Once
+	done chan struct{}
+	join chan struct{}
+}
+
+// NewAPIClient creates and returns a new API client submitting metrics with
+// apiKey to the default datadog site.
+func NewAPIClient(apiKey string) *APIClient {
+	return NewAPIClientWith(APIClientConfig{
+		APIKey: apiKey,
+	})
+}
+
+// NewAPIClientWith creates and returns a new API client configured with the
+// given config.
+func NewAPIClientWith(config APIClientConfig) *APIClient {
+	if len(config.Site) == 0 {
+		config.Site = DefaultSite
+	}
+
+	if len(config.Endpoint) == 0 {
+		config.Endpoint = "https://api." + config.Site
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultAPITimeout
+	}
+
+	if config.FlushInterval == 0 {
+		config.FlushInterval = DefaultAPIFlushInterval
+	}
+
+	switch {
+	case config.MaxRetries == 0:
+		config.MaxRetries = DefaultAPIMaxRetries
+	case config.MaxRetries < 0:
+		config.MaxRetries = 0
+	}
+
+	if config.Filters == nil {
+		config.Filters = DefaultFilters
+	}
+
+	filters := make(map[string]struct{}, len(config.Filters))
+	for _, f := range config.Filters {
+		filters[f] = struct{}{}
+	}
+
+	c := &APIClient{
+		url:          strings.TrimSuffix(config.Endpoint, "/") + "/api/v2/series",
+		apiKey:       config.APIKey,
+		maxRetries:   config.MaxRetries,
+		tags:         config.Tags,
+		filters:      filters,
+		clockSkew:    config.ClockSkew,
+		errorHandler: config.ErrorHandler,
+		series:       make(map[string]*apiSeriesState),
+		done:         make(chan struct{}),
+		join:         make(chan struct{}),
+		http: http.Client{
+			Timeout:   config.Timeout,
+			Transport: config.Transport,
+		},
+	}
+
+	if config.Transport == nil && len(config.ProxyURL) != 0 {
+		if proxy, err := url.Parse(config.ProxyURL); err != nil {
+			c.handleError(fmt.Errorf("datadog: invalid ProxyURL: %w", err))
+		} else {
+			transport := http.DefaultTransport.(*http.Transport).Clone()
+			transport.Proxy = http.ProxyURL(proxy)
+			c.http.Transport = transport
+		}
+	}
+
+	if config.FlushInterval > 0 {
+		go c.run(config.FlushInterval)
+	} else {
+		close(c.join)
+	}
+
+	return c
+}
+
+// HandleMeasures satisfies the stats.Handler interface.
+func (c *APIClient) HandleMeasures(time time.Time, measures ...stats.Measure) {
+	time = time.Add(c.clockSkew)
+
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, m := range measures {
+		for _, field := range m.Fields {
+			c.observe(time, m, field)
+		}
+	}
+}
+
+func (c *APIClient) observe(t time.Time, m stats.Measure, field stats.Field) {
+	name := metricName(m, field)
+	ftype := field.Type()
+	tags := c.filterTags(m.Tags)
+
+	k := make([]byte, 0, 128)
+	k = append(k, name...)
+	k = append(k, 0, byte(ftype))
+	for _, tag := range tags {
+		k = append(k, 0)
+		k = append(k, tag.Name...)
+		k = append(k, ':')
+		k = append(k, tag.Value...)
+	}
+
+	s := c.series[string(k)]
+	if s == nil {
+		s = newAPISeriesState(name, ftype, c.mergeTags(tags))
+		c.series[string(k)] = s
+	}
+
+	// Sampled measures stand for 1/rate measures, datadog has no sample rates
+	// in the series API so they are scaled here.
+	weight := 1.0
+	if m.SampleRate > 0 && m.SampleRate < 1 {
+		weight = 1 / m.SampleRate
+	}
+
+	s.observe(t, floatValue(field.Value), weight)
+}
+
+// filterTags returns tags without those listed in the Filters of the client.
+func (c *APIClient) filterTags(tags []stats.Tag) []stats.Tag {
+	for i, t := range tags {
+		if _, ok := c.filters[t.Name]; !ok {
+			continue
+		}
+
+		// Allocate a new list on the first filtered tag, the measure's
+		// tags are read-only.
+		filtered := append(make([]stats.Tag, 0, len(tags)-1), tags[:i]...)
+		for _, t := range tags[i+1:] {
+			if _, ok := c.filters[t.Name]; !ok {
+				filtered = append(filtered, t)
+			}
+		}
+		return filtered
+	}
+	return tags
+}
+
+func (c *APIClient) mergeTags(tags []stats.Tag) []stats.Tag {
+	return format{tags: c.tags}.mergeTags(tags)
+}
+
+// Flush submits the series accumulated since the last flush, satisfies the
+// stats.Flusher interface.
+func (c *APIClient) Flush() {
+	c.mutex.Lock()
+	series := c.series
+	c.series = make(map[string]*apiSeriesState, len(series))
+	c.mutex.Unlock()
+
+	if len(series) == 0 {
+		return
+	}
+
+	if err := c.submit(makeAPIPayload(series)); err != nil {
+		c.handleError(err)
+	}
+}
+
+// Close stops the periodic submissions and flushes the client, satisfies the
+// io.Closer interface.
+func (c *APIClient) Close() error {
+	c.once.Do(func() { close(c.done) })
+	<-c.join
+	c.Flush()
+	return nil
+}
+
+func (c *APIClient) run(interval time.Duration) {
+	defer close(c.join)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+func (c *APIClient) submit(payload apiPayload) error {
+	body := &bytes.Buffer{}
+	zw := gzip.NewWriter(body)
+
+	if err := json.NewEncoder(zw).Encode(payload); err != nil {
+		return fmt.Errorf("datadog: encoding series: %w", err)
+	}
+	if err := zw.Close(); err != nil {
+		return fmt.Errorf("datadog: compressing series: %w", err)
+	}
+
+	var err error
+	var delay time.Duration
+
+	for attempt := 0; attempt <= c.maxRetries; attempt++ {
+		if attempt != 0 {
+			select {
+			case <-time.After(delay):
+			case <-c.done:
+				// The client is closing, retry without waiting.
+			}
+		}
+
+		var retry bool
+		if delay, retry, err = c.post(body.Bytes()); !retry {
+			break
+		}
+
+		if delay == 0 {
+			delay = minRetryDelay << uint(attempt)
+		}
+		if delay > maxRetryDelay || delay < 0 {
+			delay = maxRetryDelay
+		}
+	}
+
+	if err != nil {
+		return fmt.Errorf("datadog: submitting %d series to %s: %w", len(payload.Series), c.url, err)
+	}
+	return nil
+}
+
+// post sends a submission request, returning whether it should be retried and
+// the delay requested by the API before retrying.
+func (c *APIClient) post(body []byte) (time.Duration, bool, error) {
+	req, err := http.NewRequest("POST", c.url, bytes.NewReader(body))
+	if err != nil {
+		return 0, false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Content-Encoding", "gzip")
+	req.Header.Set("DD-API-KEY", c.apiKey)
+
+	res, err := c.http.Do(req)
+	if err != nil {
+		return 0, true, err
+	}
+	msg, _ := ioutil.ReadAll(io.LimitReader(res.Body, 1024))
+	res.Body.Close()
+
+	switch {
+	case res.StatusCode < 300:
+		return 0, false, nil
+	case res.StatusCode == http.StatusTooManyRequests:
+		return retryAfter(res.Header), true, fmt.Errorf("rate limited: %s", res.Status)
+	case res.StatusCode >= 500:
+		return 0, true, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(msg))
+	default:
+		return 0, false, fmt.Errorf("%s: %s", res.Status, bytes.TrimSpace(msg))
+	}
+}
+
+// retryAfter returns the delay requested by rate-limited responses, in the
+// Retry-After or X-RateLimit-Reset headers, or zero if there was none.
+func retryAfter(h http.Header) time.Duration {
+	for _, name := range []string{"Retry-After", "X-RateLimit-Reset"} {
+		if s, err := strconv.Atoi(h.Get(name)); err == nil && s >= 0 {
+			return time.Duration(s) * time.Second
+		}
+	}
+	return 0
+}
+
+func (c *APIClient) handleError(err error) {
+	if c.errorHandler != nil {
+		c.errorHandler(err)
+	} else {
+		logError(err)
+	}
+}
+
+// Metric types of the v2 series API.
+const (
+	apiCount = 1
+	apiGauge = 3
+)
+
+type apiPayload struct {
+	Series []apiSeries `json:"series"`
+}
+
+type apiSeries struct {
+	Metric    string        `json:"metric"`
+	Type      int           `json:"type"`
+	Points    []apiPoint    `json:"points"`
+	Tags      []string      `json:"tags,omitempty"`
+	Resources []apiResource `json:"resources,omitempty"`
+}
+
+type apiPoint struct {
+	Timestamp int64   `json:"timestamp"`
+	Value     float64 `json:"value"`
+}
+
+type apiResource struct {
+	Name string `json:"name"`
+	Type string `json:"type"`
+}
+
+// apiSeriesState accumulates the values of a series between two flushes.
+type apiSeriesState struct {
+	name  string
+	ftype stats.FieldType
+	tags  []string
+	host  string
+	time  time.Time
+	count float64
+	sum   float64
+	last  float64
+	min   float64
+	max   float64
+
+	// Values observed by set series, which report the number of unique
+	// values.
+	unique map[float64]struct{}
+}
+
+func newAPISeriesState(name string, ftype stats.FieldType, tags []stats.Tag) *apiSeriesState {
+	s := &apiSeriesState{
+		name:  name,
+		ftype: ftype,
+		tags:  make([]string, 0, len(tags)),
+	}
+
+	for _, t := range tags {
+		if t.Name == "host" {
+			s.host = t.Value
+		} else {
+			s.tags = append(s.tags, t.Name+":"+t.Value)
+		}
+	}
+
+	return s
+}
+
+func (s *apiSeriesState) observe(t time.Time, value float64, weight float64) {
+	if s.count == 0 || value < s.min {
+		s.min = value
+	}
+	if s.count == 0 || value > s.max {
+		s.max = value
+	}
+	if t.After(s.time) {
+		s.time = t
+	}
+	s.count += weight
+	s.sum += value * weight
+	s.last = value
+
+	if s.ftype == stats.SetType {
+		if s.unique == nil {
+			s.unique = make(map[float64]struct{})
+		}
+		s.unique[value] = struct{}{}
+	}
+}
+
+func (s *apiSeriesState) append(series []apiSeries) []apiSeries {
+	add := func(suffix string, mtype int, value float64) {
+		a := apiSeries{
+			Metric: s.name + suffix,
+			Type:   mtype,
+			Points: []apiPoint{{Timestamp: s.time.Unix(), Value: value}},
+			Tags:   s.tags,
+		}
+		if len(s.host) != 0 {
+			a.Resources = []apiResource{{Name: s.host, Type: "host"}}
+		}
+		series = append(series, a)
+	}
+
+	switch s.ftype {
+	case stats.Counter:
+		add("", apiCount, s.sum)
+	case stats.Gauge:
+		add("", apiGauge, s.last)
+	case stats.SetType:
+		add("", apiGauge, float64(len(s.unique)))
+	default:
+		add(".count", apiCount, s.count)
+		add(".avg", apiGauge, s.sum/s.count)
+		add(".min", apiGauge, s.min)
+		add(".max", apiGauge, s.max)
+	}
+
+	return series
+}
+
+func makeAPIPayload(series map[string]*apiSeriesState) apiPayload {
+	keys := make([]string, 0, len(series))
+	for k := range series {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	payload := apiPayload{Series: make([]apiSeries, 0, len(series))}
+	for _, k := range keys {
+		payload.Series = series[k].append(payload.Series)
+	}
+	return payload
+}
@@ -0,0 +1,277 @@
+package datadog
+
+import (
+	"compress/gzip"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestAPIClient(t *testing.T) {
+	var payload apiPayload
+	var header http.Header
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		header = req.Header
+
+		if req.URL.Path != "/api/v2/series" {
+			t.Errorf("bad path: %s", req.URL.Path)
+		}
+
+		r, err := gzip.NewReader(req.Body)
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		if err := json.NewDecoder(r).Decode(&payload); err != nil {
+			t.Error(err)
+		}
+		res.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	client := NewAPIClientWith(APIClientConfig{
+		APIKey:        "0123456789",
+		Endpoint:      server.URL,
+		FlushInterval: -1,
+		Tags:          []stats.Tag{stats.T("env", "test")},
+	})
+
+	now := time.Unix(1500000000, 0)
+	tags := []stats.Tag{stats.T("host", "web1"), stats.T("service", "api")}
+
+	for _, value := range []float64{1, 3} {
+		client.HandleMeasures(now, stats.Measure{
+			Name: "request",
+			Fields: []stats.Field{
+				stats.MakeField("count", value, stats.Counter),
+				stats.MakeField("inflight", value, stats.Gauge),
+				stats.MakeField("rtt", value, stats.Histogram),
+			},
+			Tags: tags,
+		})
+	}
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if key := header.Get("DD-API-KEY"); key != "0123456789" {
+		t.Errorf("bad API key: %q", key)
+	}
+	if enc := header.Get("Content-Encoding"); enc != "gzip" {
+		t.Errorf("bad content encoding: %q", enc)
+	}
+
+	series := func(metric string, mtype int, value float64) apiSeries {
+		return apiSeries{
+			Metric:    metric,
+			Type:      mtype,
+			Points:    []apiPoint{{Timestamp: 1500000000, Value: value}},
+			Tags:      []string{"service:api", "env:test"},
+			Resources: []apiResource{{Name: "web1", Type: "host"}},
+		}
+	}
+
+	expect := apiPayload{Series: []apiSeries{
+		series("request.count", apiCount, 4),
+		series("request.inflight", apiGauge, 3),
+		series("request.rtt.count", apiCount, 2),
+		series("request.rtt.avg", apiGauge, 2),
+		series("request.rtt.min", apiGauge, 1),
+		series("request.rtt.max", apiGauge, 3),
+	}}
+
+	if !reflect.DeepEqual(payload, expect) {
+		t.Errorf("bad payload:\nwant: %+v\ngot:  %+v", expect, payload)
+	}
+}
+
+func TestAPIClientRateLimited(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		if atomic.AddInt32(&requests, 1) == 1 {
+			res.Header().Set("Retry-After", "0")
+			res.WriteHeader(http.StatusTooManyRequests)
+			return
+		}
+		res.WriteHeader(http.StatusAccepted)
+	}))
+	defer server.Close()
+
+	var errors int32
+
+	client := NewAPIClientWith(APIClientConfig{
+		Endpoint:      server.URL,
+		FlushInterval: -1,
+		ErrorHandler:  func(error) { atomic.AddInt32(&errors, 1) },
+	})
+
+	client.HandleMeasures(time.Now(), stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Close()
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("bad number of requests: %d", n)
+	}
+	if n := atomic.LoadInt32(&errors); n != 0 {
+		t.Errorf("bad number of errors: %d", n)
+	}
+}
+
+func TestAPIClientGivesUp(t *testing.T) {
+	var requests int32
+
+	server := httptest.NewServer(http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		atomic.AddInt32(&requests, 1)
+		res.WriteHeader(http.StatusServiceUnavailable)
+	}))
+	defer server.Close()
+
+	var errs []error
+
+	client := NewAPIClientWith(APIClientConfig{
+		Endpoint:      server.URL,
+		FlushInterval: -1,
+		MaxRetries:    1,
+		ErrorHandler:  func(err error) { errs = append(errs, err) },
+	})
+
+	client.HandleMeasures(time.Now(), stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+	client.Flush()
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("bad number of requests: %d", n)
+	}
+	if len(errs) != 1 {
+		t.Errorf("bad errors: %v", errs)
+	}
+
+	// Series that could not be submitted are dropped.
+	client.Close()
+
+	if n := atomic.LoadInt32(&requests); n != 2 {
+		t.Errorf("bad number of requests after closing the client: %d", n)
+	}
+}
+
+func TestAPIClientSampleRate(t *testing.T) {
+	client := NewAPIClientWith(APIClientConfig{FlushInterval: -1})
+	defer client.Close()
+
+	now := time.Unix(1500000000, 0)
+
+	for _, field := range []stats.Field{
+		stats.MakeField("count", 1, stats.Counter),
+		stats.MakeField("rtt", 2, stats.Histogram),
+	} {
+		client.HandleMeasures(now, stats.Measure{
+			Name:       "request",
+			Fields:     []stats.Field{field},
+			SampleRate: 0.25,
+		})
+	}
+
+	client.mutex.Lock()
+	payload := makeAPIPayload(client.series)
+	client.series = map[string]*apiSeriesState{}
+	client.mutex.Unlock()
+
+	values := map[string]float64{}
+	for _, s := range payload.Series {
+		values[s.Metric] = s.Points[0].Value
+	}
+
+	for metric, value := range map[string]float64{
+		"request.count":     4,
+		"request.rtt.count": 4,
+		"request.rtt.avg":   2,
+	} {
+		if values[metric] != value {
+			t.Errorf("bad value of %s: %g", metric, values[metric])
+		}
+	}
+}
+
+func TestAPIClientSets(t *testing.T) {
+	client := NewAPIClientWith(APIClientConfig{FlushInterval: -1})
+	defer client.Close()
+
+	eng := stats.NewEngine("", client)
+	for _, user := range []int64{1, 2, 1, 3, 2} {
+		eng.Unique("request.user", user)
+	}
+
+	client.mutex.Lock()
+	payload := makeAPIPayload(client.series)
+	client.series = map[string]*apiSeriesState{}
+	client.mutex.Unlock()
+
+	if n := len(payload.Series); n != 1 {
+		t.Fatal("bad series count:", n)
+	}
+
+	if s := payload.Series[0]; s.Metric != "request.user" || s.Type != apiGauge || s.Points[0].Value != 3 {
+		t.Errorf("bad series: %+v", s)
+	}
+}
+
+func TestAPIClientFilters(t *testing.T) {
+	client := NewAPIClientWith(APIClientConfig{FlushInterval: -1})
+	defer client.Close()
+
+	for _, path := range []string{"/a", "/b"} {
+		client.HandleMeasures(time.Now(), stats.Measure{
+			Name:   "request",
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("http_req_path", path), stats.T("method", "GET")},
+		})
+	}
+
+	client.mutex.Lock()
+	payload := makeAPIPayload(client.series)
+	client.series = map[string]*apiSeriesState{}
+	client.mutex.Unlock()
+
+	if n := len(payload.Series); n != 1 {
+		t.Fatal("bad series count:", n)
+	}
+
+	if s := payload.Series[0]; !reflect.DeepEqual(s.Tags, []string{"method:GET"}) || s.Points[0].Value != 2 {
+		t.Errorf("bad series: %+v", s)
+	}
+}
+
+func TestAPIClientClockSkew(t *testing.T) {
+	client := NewAPIClientWith(APIClientConfig{
+		FlushInterval: -1,
+		ClockSkew:     -time.Minute,
+	})
+	defer client.Close()
+
+	client.HandleMeasures(time.Unix(1500000000, 0), stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+
+	client.mutex.Lock()
+	payload := makeAPIPayload(client.series)
+	client.series = map[string]*apiSeriesState{}
+	client.mutex.Unlock()
+
+	if ts := payload.Series[0].Points[0].Timestamp; ts != 1500000000-60 {
+		t.Error("bad timestamp:", ts)
+	}
+}
@@ -33,22 +33,26 @@ func appendMetric(b []byte, m Metric) []byte {
 }
 
 func appendEvent(b []byte, e Event) []byte {
+	// The lengths of the title and text are the ones of their escaped forms,
+	// it is what the agent reads from the datagram.
+	title := strings.Replace(e.Title, "\n", "\\n", -1)
+	text := strings.Replace(e.Text, "\n", "\\n", -1)
+
 	b = append(b, '_', 'e', '{')
-	b = strconv.AppendInt(b, int64(len(e.Title)), 10)
+	b = strconv.AppendInt(b, int64(len(title)), 10)
 	b = append(b, ',')
-	b = strconv.AppendInt(b, int64(len(e.Text)), 10)
+	b = strconv.AppendInt(b, int64(len(text)), 10)
 	b = append(b, '}', ':')
-	b = append(b, e.Title...)
+	b = append(b, title...)
 	b = append(b, '|')
+	b = append(b, text...)
 
-	b = append(b, strings.Replace(e.Text, "\n", "\\n", -1)...)
-
-	if e.Priority != EventPriorityNormal {
+	if e.Priority != EventPriorityNormal && len(e.Priority) != 0 {
 		b = append(b, '|', 'p', ':')
 		b = append(b, e.Priority...)
 	}
 
-	if e.AlertType != EventAlertTypeInfo {
+	if e.AlertType != EventAlertTypeInfo && len(e.AlertType) != 0 {
 		b = append(b, '|', 't', ':')
 		b = append(b, e.AlertType...)
 	}
@@ -81,6 +85,39 @@ func appendEvent(b []byte, e Event) []byte {
 	return append(b, '\n')
 }
 
+func appendServiceCheck(b []byte, sc ServiceCheck) []byte {
+	b = append(b, '_', 's', 'c', '|')
+	b = append(b, sc.Name...)
+	b = append(b, '|')
+	b = strconv.AppendInt(b, int64(sc.Status), 10)
+
+	if sc.Ts != int64(0) {
+		b = append(b, '|', 'd', ':')
+		b = strconv.AppendInt(b, sc.Ts, 10)
+	}
+
+	if len(sc.Host) > 0 {
+		b = append(b, '|', 'h', ':')
+		b = append(b, sc.Host...)
+	}
+
+	if n := len(sc.Tags); n != 0 {
+		b = append(b, '|', '#')
+		b = appendTags(b, sc.Tags)
+	}
+
+	// The message must be the last field, the agent reads it until the end of
+	// the line.
+	if len(sc.Message) > 0 {
+		b = append(b, '|', 'm', ':')
+		b = append(b, serviceCheckMessageReplacer.Replace(sc.Message)...)
+	}
+
+	return append(b, '\n')
+}
+
+var serviceCheckMessageReplacer = strings.NewReplacer("\n", "\\n", "m:", "m\\:")
+
 func appendTags(b []byte, tags []stats.Tag) []byte {
 	for i, t := range tags {
 		if i != 0 {
@@ -1,6 +1,10 @@
 package datadog
 
-import "testing"
+import (
+	"testing"
+
+	"github.com/segmentio/stats"
+)
 
 func TestAppendMetric(t *testing.T) {
 	for _, test := range testMetrics {
@@ -23,3 +27,83 @@ func BenchmarkAppendMetric(b *testing.B) {
 		})
 	}
 }
+
+func TestAppendEvent(t *testing.T) {
+	tests := []struct {
+		e Event
+		s string
+	}{
+		{
+			e: Event{Title: "deploy", Text: "v1.2.3"},
+			s: "_e{6,6}:deploy|v1.2.3\n",
+		},
+		{
+			// The text length is the one of the escaped text.
+			e: Event{Title: "deploy", Text: "line1\nline2"},
+			s: "_e{6,12}:deploy|line1\\nline2\n",
+		},
+		{
+			// A new line in the title must not split the datagram either.
+			e: Event{Title: "deploy\nfailed", Text: "v1.2.3"},
+			s: "_e{14,6}:deploy\\nfailed|v1.2.3\n",
+		},
+		{
+			e: Event{
+				Title:     "déploiement",
+				Text:      "v1.2.3",
+				Priority:  EventPriorityLow,
+				AlertType: EventAlertTypeSuccess,
+				Tags:      []stats.Tag{stats.T("service", "api")},
+			},
+			s: "_e{12,6}:déploiement|v1.2.3|p:low|t:success|#service:api\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			if s := string(appendEvent(nil, test.e)); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+
+			e, err := parseEvent(test.s)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if e.Title != test.e.Title || e.Text != test.e.Text {
+				t.Errorf("the event did not survive a round trip: %#v", e)
+			}
+		})
+	}
+}
+
+func TestAppendServiceCheck(t *testing.T) {
+	tests := []struct {
+		sc ServiceCheck
+		s  string
+	}{
+		{
+			sc: ServiceCheck{Name: "api.health", Status: ServiceCheckOK},
+			s:  "_sc|api.health|0\n",
+		},
+		{
+			sc: ServiceCheck{
+				Name:    "api.health",
+				Status:  ServiceCheckCritical,
+				Ts:      1500000000,
+				Host:    "localhost",
+				Tags:    []stats.Tag{stats.T("service", "api")},
+				Message: "database unreachable\nm:retrying",
+			},
+			s: "_sc|api.health|2|d:1500000000|h:localhost|#service:api|m:database unreachable\\nm\\:retrying\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			if s := string(appendServiceCheck(nil, test.sc)); s != test.s {
+				t.Errorf("\n<<< %#v\n>>> %#v", test.s, s)
+			}
+		})
+	}
+}
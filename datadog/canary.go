@@ -0,0 +1,35 @@
+package datadog
+
+import (
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// canary emits the gauge of clients configured with a CanaryMetric.
+type canary struct {
+	last int64 // unix time in nanoseconds, accessed atomically
+	name string
+	tags []stats.Tag
+}
+
+// measure returns the canary gauge for a flush happening at now, and records
+// now as the time of the last emission.
+func (c *canary) measure(now time.Time) stats.Measure {
+	atomic.StoreInt64(&c.last, now.UnixNano())
+	return stats.Measure{
+		Name:   c.name,
+		Fields: []stats.Field{stats.MakeField("", float64(now.UnixNano())/1e9, stats.Gauge)},
+		Tags:   c.tags,
+	}
+}
+
+// lastEmitted returns the time at which the canary was last emitted, or the
+// zero time if it never was.
+func (c *canary) lastEmitted() time.Time {
+	if t := atomic.LoadInt64(&c.last); t != 0 {
+		return time.Unix(0, t)
+	}
+	return time.Time{}
+}
@@ -0,0 +1,46 @@
+package datadog
+
+import (
+	"sync"
+	"sync/atomic"
+
+	"github.com/segmentio/stats"
+)
+
+// channelSink accumulates measures between flushes of clients configured to
+// deliver metrics to a channel.
+type channelSink struct {
+	dropped  uint64 // accessed atomically, must be 64 bits aligned
+	mutex    sync.Mutex
+	measures []stats.Measure
+	channel  chan<- []stats.Measure
+}
+
+func (s *channelSink) handleMeasures(measures ...stats.Measure) {
+	s.mutex.Lock()
+
+	// The stats.Handler interface doesn't allow retaining the measures passed
+	// to HandleMeasures.
+	for _, m := range measures {
+		s.measures = append(s.measures, m.Clone())
+	}
+
+	s.mutex.Unlock()
+}
+
+func (s *channelSink) flush() {
+	s.mutex.Lock()
+	measures := s.measures
+	s.measures = nil
+	s.mutex.Unlock()
+
+	if len(measures) == 0 {
+		return
+	}
+
+	select {
+	case s.channel <- measures:
+	default:
+		atomic.AddUint64(&s.dropped, uint64(len(measures)))
+	}
+}
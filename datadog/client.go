@@ -2,10 +2,18 @@ package datadog
 
 import (
 	"bytes"
+	"context"
+	"errors"
+	"fmt"
 	"io"
 	"log"
+	"math"
 	"net"
 	"os"
+	"reflect"
+	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
 	"time"
 
@@ -21,8 +29,26 @@ const (
 	// datadog.
 	DefaultBufferSize = 1024
 
+	// DefaultUnixBufferSize is the default size for batches of metrics sent to
+	// datadog agents listening on unix datagram sockets, which accept larger
+	// datagrams than the networks between hosts.
+	DefaultUnixBufferSize = 8192
+
 	// MaxBufferSize is a hard-limit on the max size of the datagram buffer.
 	MaxBufferSize = 65507
+
+	// DefaultPriorityFlushInterval is the default minimum interval between
+	// two flushes triggered by priority metrics.
+	DefaultPriorityFlushInterval = 100 * time.Millisecond
+
+	// DefaultShutdownTimeout is the default amount of time that closing a
+	// client may spend flushing its last metrics, including retried writes
+	// when it is configured with ShutdownRetries.
+	DefaultShutdownTimeout = 1 * time.Second
+
+	// DefaultKeepAlive is the default keep-alive period of stream connections
+	// returned by the DialFunc of clients.
+	DefaultKeepAlive = 30 * time.Second
 )
 
 // DefaultFilter is the default tag to filter before sending to
@@ -37,21 +63,359 @@ var (
 // The ClientConfig type is used to configure datadog clients.
 type ClientConfig struct {
 	// Address of the datadog database to send metrics to.
+	//
+	// Metrics are sent over UDP by default, the address may be prefixed with
+	// a network scheme to use a different one, for example to send metrics to
+	// an agent listening on a unix datagram socket:
+	//
+	//	unixgram:///var/run/datadog/dsd.socket
+	//
+	// Supported networks are udp, udp4, udp6, and unixgram, the unix scheme
+	// used by the datadog agent configuration is an alias for unixgram.
 	Address string
 
-	// Maximum size of batch of events sent to datadog.
+	// Maximum size of batch of events sent to datadog. The default is
+	// DefaultBufferSize, or DefaultUnixBufferSize for unix datagram sockets.
 	BufferSize int
 
 	// List of tags to filter. If left nil is set to DefaultFilters.
 	Filters []string
+
+	// TagFormat selects how tags are encoded in the metrics sent by the
+	// client, the default is the dogstatsd format. Events and service checks
+	// are only supported by dogstatsd and always use it.
+	TagFormat TagFormat
+
+	// MeasureFilter is called with each measure before it is serialized, it
+	// returns the measure to send, which may have been renamed or have had
+	// tags removed, or false to drop it. The function is called concurrently
+	// and must not modify the Fields or Tags slices of the measure in place,
+	// it has to copy them instead.
+	//
+	// The filter is not applied to measures delivered to a Channel, nor to
+	// the metrics that the client reports about itself.
+	MeasureFilter func(stats.Measure) (stats.Measure, bool)
+
+	// EmitMultiplier amplifies or samples down the volume of metrics sent by
+	// the client, it is intended to be used as a load-testing aid and should
+	// never be set on production clients.
+	//
+	// Values greater than one emit each metric that many times, every copy
+	// carrying an EmitCopyTag tag with a distinct value so the agent doesn't
+	// collapse them. Values between zero and one emit each metric with that
+	// probability. A fractional part on values greater than one is applied as
+	// the probability of emitting an extra copy.
+	//
+	// If left to zero, metrics are emitted once.
+	EmitMultiplier float64
+
+	// SignificantFigures sets the number of significant figures that float
+	// and duration values are rounded to before being sent, which reduces the
+	// size of the datagrams when full precision isn't necessary.
+	//
+	// If left to zero, values are sent with full precision.
+	SignificantFigures int
+
+	// SelfMetrics enables the emission of metrics reporting on the health of
+	// the client itself, see the SelfMetricsPrefix constant for details.
+	//
+	// Self metrics describe the datagrams written by the client, they cannot
+	// be enabled on clients delivering metrics to a Channel.
+	SelfMetrics bool
+
+	// SelfMetricsSampling reduces the volume of self metrics on clients that
+	// flush at a high rate by only sending them every N flushes. Counters
+	// accumulate between emissions so no events are lost.
+	//
+	// If left to zero, self metrics are sent on every flush.
+	SelfMetricsSampling int
+
+	// SelfMetricsHeartbeat is the name of a gauge sent with the self metrics
+	// whose value is the number of metrics written by the client since the
+	// previous emission, so a single metric reports both liveness and
+	// activity. If left empty, the gauge is not sent.
+	SelfMetricsHeartbeat string
+
+	// DialFunc is used by the client to establish the connection to Address,
+	// it makes it possible to send metrics over custom transports (a proxy
+	// for example) or to inject connections in tests.
+	//
+	// The socket buffer of the connection is only tuned when DialFunc returns
+	// a *net.UDPConn.
+	//
+	// If nil, net.Dial is used.
+	DialFunc func(network, address string) (net.Conn, error)
+
+	// Channel configures the client to deliver metrics to in-process
+	// consumers instead of sending them over the network. On each flush, the
+	// measures handled since the previous flush are sent to the channel, the
+	// client doesn't block if the channel isn't ready to receive and drops
+	// the measures instead.
+	//
+	// When Channel is set, the client doesn't dial Address.
+	Channel chan<- []stats.Measure
+
+	// PriorityMetrics is a list of metric names that trigger a flush of the
+	// client when they are produced, instead of waiting for the buffer to
+	// fill up or for the next call to Flush. The flush runs in a background
+	// goroutine, producing the metrics doesn't block on the connection.
+	PriorityMetrics []string
+
+	// PriorityFlushInterval is the minimum amount of time between two flushes
+	// triggered by priority metrics, it prevents flooding the agent when
+	// priority metrics are produced at a high rate.
+	//
+	// If left to zero, DefaultPriorityFlushInterval is used.
+	PriorityFlushInterval time.Duration
+
+	// BooleanMetrics is a list of metric names that represent boolean values
+	// and must only be reported as 0 or 1. Values greater than zero are sent
+	// as 1, values lower than zero as 0, and the violations are counted and
+	// reported by the stats.client.boolean_violations self metric.
+	BooleanMetrics []string
+
+	// MaxValue is the maximum absolute value of metrics sent by the client,
+	// it protects dashboards and the agent from absurd values produced by
+	// bugs. MaxValuePolicy defines how values exceeding the limit are
+	// handled.
+	//
+	// If left to zero, values are not limited.
+	MaxValue float64
+
+	// MaxValuePolicy defines what the client does with metrics which have
+	// values exceeding MaxValue. The default is to clamp the values.
+	MaxValuePolicy ValuePolicy
+
+	// Separator is the byte written after each metric, the client batches
+	// multiple metrics in a single datagram by splitting its output on this
+	// byte. It must be an ASCII control character, which never appears in
+	// metric names or tags. The default is '\n', which is what the dogstatsd
+	// agent expects.
+	Separator byte
+
+	// Namespace is prepended to the names of all metrics sent by the client,
+	// including self metrics. For example "myservice." turns a "requests"
+	// metric into "myservice.requests".
+	Namespace string
+
+	// UseDistributions makes the client send histograms as distributions,
+	// percentiles of distributions are computed by datadog across all hosts
+	// instead of by each agent.
+	UseDistributions bool
+
+	// Tags is a list of tags added to all metrics sent by the client. Tags of
+	// the measures take precedence when they have the same name.
+	Tags []stats.Tag
+
+	// HostTag enables adding a "host" tag to all metrics, its value is Host
+	// or the name of the host reported by the kernel if Host is empty. Like
+	// other Tags, it doesn't override the host tags that measures carry.
+	HostTag bool
+	Host    string
+
+	// CanaryMetric is the name of a gauge sent on every flush whose value is
+	// the unix time of the flush in seconds, tagged with CanaryTags. The time
+	// of the last emission is reported by the client's Stats method, external
+	// checkers can use it to verify that the metric made it through the whole
+	// pipeline (by reading it back from the datadog API for example).
+	//
+	// If left empty, no canary is sent.
+	CanaryMetric string
+
+	// CanaryTags is the list of tags set on the CanaryMetric, they should
+	// uniquely identify the client.
+	CanaryTags []stats.Tag
+
+	// TypeTags configures tags added to metrics depending on their type, after
+	// the tags of the measures. For example, this configuration adds a unit
+	// to histograms and leaves other metrics unchanged:
+	//
+	//	TypeTags: map[stats.FieldType][]stats.Tag{
+	//		stats.Histogram: {stats.T("unit", "ms")},
+	//	}
+	TypeTags map[stats.FieldType][]stats.Tag
+
+	// KeepAlive is the keep-alive period set on connections which support it
+	// (like TCP connections returned by DialFunc), so idle periods between
+	// flushes don't get them dropped by middleboxes. It has no effect on UDP
+	// connections.
+	//
+	// If left to zero, DefaultKeepAlive is used, a negative value disables
+	// keep-alives.
+	KeepAlive time.Duration
+
+	// MaxReconnects enables replacing the connection of the client when
+	// writes keep failing, or when it could not be established when the
+	// client was created. Reconnection attempts are spaced by an exponential
+	// backoff, the client gives up after MaxReconnects consecutive failed
+	// attempts.
+	//
+	// If left to zero, the client never reconnects.
+	MaxReconnects int
+
+	// ErrorHandler is called with the errors that the client cannot return to
+	// the program, like failures to dial or write to the connection. It is
+	// called from the goroutines handling and flushing measures, so it must be
+	// safe to use concurrently.
+	//
+	// The errors are prefixed with "datadog: ", those returned by the
+	// connection are wrapped and can be tested with errors.Is.
+	//
+	// If nil, errors are logged with the standard logger, except failed writes
+	// which are silently dropped.
+	ErrorHandler func(error)
+
+	// BeforeFlush and AfterFlush are called with the connection of the client
+	// before and after each batch of metrics is written to it. Programs that
+	// deliver metrics to custom connections (see DialFunc) may use them to
+	// add their own framing, like batch headers and footers.
+	BeforeFlush func(io.Writer)
+	AfterFlush  func(io.Writer)
+
+	// ShutdownRetries is the maximum number of times the client retries a
+	// failed write of its last metrics when it is closed, waiting for an
+	// exponentially growing delay between attempts. This maximizes the chances
+	// of the metrics landing when the agent is momentarily unavailable (during
+	// a rolling restart for example).
+	//
+	// If left to zero, failed writes are not retried.
+	ShutdownRetries int
+
+	// ShutdownTimeout bounds the amount of time that Close may spend on the
+	// final flush, including retried writes, the default is
+	// DefaultShutdownTimeout.
+	ShutdownTimeout time.Duration
 }
 
+// DefaultSeparator is the byte separating metrics within a datagram when none
+// is configured.
+const DefaultSeparator = '\n'
+
+// Validate checks that config is coherent, returning an error describing the
+// first problem it found or nil if the configuration is valid.
+//
+// NewClientWith calls Validate, programs may also call it to detect invalid
+// configurations early (when loading them from a file for example).
+func (config ClientConfig) Validate() error {
+	switch {
+	case config.Channel != nil && config.DialFunc != nil:
+		return errors.New("datadog: DialFunc cannot be set on clients delivering metrics to a Channel since they never dial")
+	case config.Channel != nil && len(config.Address) != 0:
+		return errors.New("datadog: Address cannot be set on clients delivering metrics to a Channel since they never dial")
+	case !validNetwork(config.Address):
+		network, _ := splitNetworkAddress(config.Address)
+		return fmt.Errorf("datadog: unsupported network %q in Address, must be one of udp, udp4, udp6, or unixgram", network)
+	case config.Channel != nil && config.MaxReconnects != 0:
+		return errors.New("datadog: MaxReconnects cannot be set on clients delivering metrics to a Channel since they never dial")
+	case config.Channel != nil && config.SelfMetrics:
+		return errors.New("datadog: SelfMetrics cannot be enabled on clients delivering metrics to a Channel since they write no datagrams")
+	case config.MaxReconnects < 0:
+		return fmt.Errorf("datadog: MaxReconnects must not be negative, got %d", config.MaxReconnects)
+	case config.BufferSize < 0:
+		return fmt.Errorf("datadog: BufferSize must not be negative, got %d", config.BufferSize)
+	case config.EmitMultiplier < 0:
+		return fmt.Errorf("datadog: EmitMultiplier must not be negative, got %g", config.EmitMultiplier)
+	case config.SignificantFigures < 0:
+		return fmt.Errorf("datadog: SignificantFigures must not be negative, got %d", config.SignificantFigures)
+	case len(config.SelfMetricsHeartbeat) != 0 && !config.SelfMetrics:
+		return errors.New("datadog: SelfMetricsHeartbeat requires SelfMetrics to be enabled")
+	case config.SelfMetricsSampling < 0:
+		return fmt.Errorf("datadog: SelfMetricsSampling must not be negative, got %d", config.SelfMetricsSampling)
+	case config.ShutdownRetries < 0:
+		return fmt.Errorf("datadog: ShutdownRetries must not be negative, got %d", config.ShutdownRetries)
+	case config.ShutdownTimeout < 0:
+		return fmt.Errorf("datadog: ShutdownTimeout must not be negative, got %s", config.ShutdownTimeout)
+	case config.PriorityFlushInterval < 0:
+		return fmt.Errorf("datadog: PriorityFlushInterval must not be negative, got %s", config.PriorityFlushInterval)
+	case config.MaxValuePolicy != ClampValues && config.MaxValuePolicy != DropValues:
+		return fmt.Errorf("datadog: unknown MaxValuePolicy %d", config.MaxValuePolicy)
+	case config.TagFormat < DatadogTags || config.TagFormat > LibratoTags:
+		return fmt.Errorf("datadog: unknown TagFormat %d", config.TagFormat)
+	case config.Separator != 0 && !validSeparator(config.Separator):
+		return fmt.Errorf("datadog: Separator %q may appear in metrics, it must be an ASCII control character like '\\n'", config.Separator)
+	}
+	return nil
+}
+
+// validSeparator returns true if b can separate metrics in a datagram, the
+// byte must never appear in the serialized metrics or the datagrams would be
+// split in the middle of one.
+func validSeparator(b byte) bool {
+	return b < ' ' || b == 0x7f
+}
+
+func validNetwork(address string) bool {
+	switch network, _ := splitNetworkAddress(address); network {
+	case "udp", "udp4", "udp6", "unixgram":
+		return true
+	}
+	return false
+}
+
+// TagFormat is an enumeration of the encodings of metric tags supported by the
+// client, for agents which don't understand the dogstatsd tag extension.
+type TagFormat int
+
+const (
+	// DatadogTags appends tags to metrics in the dogstatsd format, for
+	// example request.count:1|c|#method:GET. This is the default.
+	DatadogTags TagFormat = iota
+
+	// NoTags drops the tags of metrics, producing plain statsd lines.
+	NoTags
+
+	// InfluxDBTags sets tags in the metric names in the format of the
+	// InfluxDB statsd extension, for example request.count,method=GET:1|c.
+	InfluxDBTags
+
+	// LibratoTags sets tags in the metric names in the format of the Librato
+	// statsd extension, for example request.count#method=GET:1|c.
+	LibratoTags
+)
+
+// ValuePolicy is an enumeration of the policies that a client can apply to
+// out-of-range values.
+type ValuePolicy int
+
+const (
+	// ClampValues replaces out-of-range values with the closest value within
+	// the range. The number of clamped values is reported by the
+	// stats.client.values_clamped self metric.
+	ClampValues ValuePolicy = iota
+
+	// DropValues drops metrics with out-of-range values. The number of dropped
+	// values is reported by the stats.client.values_dropped self metric.
+	DropValues
+)
+
+// EmitCopyTag is the name of the tag set on metrics duplicated by clients
+// configured with an EmitMultiplier.
+const EmitCopyTag = "emit_copy"
+
 // Client represents an datadog client that implements the stats.Handler
 // interface.
 type Client struct {
 	serializer
-	err    error
-	buffer stats.Buffer
+	buffer   stats.Buffer
+	self     *selfMetrics
+	channel  *channelSink
+	priority *priorityFlush
+	canary   *canary
+
+	// config is the configuration that the client was created with, the
+	// defaults applied. Reconfigure compares it to the new configurations to
+	// detect changes which require creating a new client.
+	config ClientConfig
+
+	// now returns the time reported by the self metrics, it is time.Now
+	// unless tests replace it.
+	now func() time.Time
+
+	// The client is only closed once, closed is closed after that and the
+	// error returned by the first call is returned by all of them.
+	closeOnce sync.Once
+	closeErr  error
+	closed    chan struct{}
 }
 
 // NewClient creates and returns a new datadog client publishing metrics to the
@@ -64,51 +428,339 @@ func NewClient(addr string) *Client {
 
 // NewClientWith creates and returns a new datadog client configured with the
 // given config.
+//
+// If the configuration is invalid the error is logged and the returned client
+// discards all metrics, the error is also returned by its Close method.
 func NewClientWith(config ClientConfig) *Client {
+	invalid := config.Validate()
+	if invalid != nil {
+		config = ClientConfig{DialFunc: dialError(invalid), ErrorHandler: config.ErrorHandler}
+	}
+
+	config = config.withDefaults()
+
+	c := &Client{
+		serializer: serializer{
+			shutdownRetries: config.ShutdownRetries,
+			beforeFlush:     config.BeforeFlush,
+			afterFlush:      config.AfterFlush,
+			errorHandler:    config.ErrorHandler,
+		},
+		config: config,
+		now:    time.Now,
+		closed: make(chan struct{}),
+	}
+	c.setFormat(config)
+
+	if len(config.PriorityMetrics) != 0 {
+		c.priority = newPriorityFlush(config.PriorityMetrics, config.PriorityFlushInterval)
+		go c.priority.run(c.Flush)
+	}
+
+	if len(config.CanaryMetric) != 0 {
+		c.canary = &canary{name: config.CanaryMetric, tags: config.CanaryTags}
+	}
+
+	if config.Channel != nil {
+		c.channel = &channelSink{channel: config.Channel}
+		return c
+	}
+
+	conn, maxBufferSize, err := dial(config.DialFunc, config.Address, config.BufferSize, config.KeepAlive)
+	if err != nil {
+		if err != invalid {
+			err = fmt.Errorf("datadog: dialing %s: %w", config.Address, err)
+		}
+		c.handleError(err)
+		// The limits of the socket are unknown, they only matter to clients
+		// which may reconnect later.
+		maxBufferSize = MaxBufferSize
+	}
+
+	// Use the size hint as an upper bound, event if the socket buffer is
+	// larger, this gives control in situations where the receive buffer size
+	// on the other side is known but cannot be controlled so the client does
+	// not produce datagrams that are too large for the receiver.
+	//
+	// Related issue: https://github.com/DataDog/dd-agent/issues/2638
+	bufferSize := maxBufferSize
+	if bufferSize > config.BufferSize {
+		bufferSize = config.BufferSize
+	}
+
+	c.bufferSize, c.maxBufferSize = int64(bufferSize), int64(maxBufferSize)
+	c.setDialError(err)
+	if conn != nil {
+		c.setConnection(conn)
+	}
+	c.buffer.BufferSize = bufferSize
+	c.buffer.Serializer = &c.serializer
+
+	if config.SelfMetrics {
+		c.self = &selfMetrics{
+			sampling:  config.SelfMetricsSampling,
+			heartbeat: config.SelfMetricsHeartbeat,
+		}
+	}
+
+	if config.MaxReconnects != 0 {
+		c.reconnector = &reconnector{
+			max: config.MaxReconnects,
+			dial: func() (net.Conn, int, error) {
+				return dial(config.DialFunc, config.Address, config.BufferSize, config.KeepAlive)
+			},
+		}
+	}
+
+	log.Printf("stats/datadog: sending metrics with a buffer of size %d B", bufferSize)
+	return c
+}
+
+// withDefaults returns a copy of config where the options left to their zero
+// values are set to their defaults.
+func (config ClientConfig) withDefaults() ClientConfig {
 	if len(config.Address) == 0 {
 		config.Address = DefaultAddress
 	}
 
 	if config.BufferSize == 0 {
-		config.BufferSize = DefaultBufferSize
+		if network, _ := splitNetworkAddress(config.Address); network == "unixgram" {
+			config.BufferSize = DefaultUnixBufferSize
+		} else {
+			config.BufferSize = DefaultBufferSize
+		}
 	}
 
 	if config.Filters == nil {
 		config.Filters = DefaultFilters
 	}
 
-	// transform filters from array to map
-	filterMap := make(map[string]struct{})
-	for _, f := range config.Filters {
-		filterMap[f] = struct{}{}
+	if config.PriorityFlushInterval == 0 {
+		config.PriorityFlushInterval = DefaultPriorityFlushInterval
 	}
 
-	c := &Client{
-		serializer: serializer{
-			filters: filterMap,
-		},
+	if config.ShutdownTimeout == 0 {
+		config.ShutdownTimeout = DefaultShutdownTimeout
 	}
 
-	conn, bufferSize, err := dial(config.Address, config.BufferSize)
-	if err != nil {
-		log.Printf("stats/datadog: %s", err)
+	if config.DialFunc == nil && config.Channel == nil {
+		config.DialFunc = net.Dial
 	}
 
-	c.conn, c.err, c.bufferSize = conn, err, bufferSize
-	c.buffer.BufferSize = bufferSize
-	c.buffer.Serializer = &c.serializer
-	log.Printf("stats/datadog: sending metrics with a buffer of size %d B", bufferSize)
+	if config.KeepAlive == 0 {
+		config.KeepAlive = DefaultKeepAlive
+	}
+
+	return config
+}
+
+// NewClientContext creates and returns a new datadog client configured with the
+// given config, which is flushed and closed when ctx is done.
+//
+// This is useful for request-scoped batches of metrics, the program records
+// metrics on the client during the lifetime of ctx and they are all sent when
+// ctx gets canceled or reaches its deadline.
+func NewClientContext(ctx context.Context, config ClientConfig) *Client {
+	c := NewClientWith(config)
+	go func() {
+		select {
+		case <-ctx.Done():
+			c.Close()
+		case <-c.closed:
+		}
+	}()
 	return c
 }
 
 // HandleMetric satisfies the stats.Handler interface.
 func (c *Client) HandleMeasures(time time.Time, measures ...stats.Measure) {
-	c.buffer.HandleMeasures(time, measures...)
+	if c.channel != nil {
+		c.channel.handleMeasures(measures...)
+	} else {
+		c.buffer.HandleMeasures(time, measures...)
+	}
+
+	if c.priority != nil && c.priority.match(measures) && c.priority.acquire() {
+		c.priority.notify()
+	}
+}
+
+// SendEvent sends e to datadog, with the global tags of the client. The event
+// is written in its own datagram, an error is returned if it doesn't fit in
+// the packet size of the client.
+func (c *Client) SendEvent(e Event) error {
+	e.Tags = c.format().mergeTags(e.Tags)
+	return c.send("event", appendEvent(nil, e))
+}
+
+// SendServiceCheck sends sc to datadog, with the global tags of the client. The
+// service check is written in its own datagram, an error is returned if it
+// doesn't fit in the packet size of the client.
+func (c *Client) SendServiceCheck(sc ServiceCheck) error {
+	sc.Tags = c.format().mergeTags(sc.Tags)
+	return c.send("service check", appendServiceCheck(nil, sc))
+}
+
+func (c *Client) send(kind string, b []byte) error {
+	if c.channel != nil {
+		return fmt.Errorf("datadog: cannot send a %s on a client delivering metrics to a Channel", kind)
+	}
+
+	if size := int(atomic.LoadInt64(&c.bufferSize)); len(b) > size {
+		return fmt.Errorf("datadog: %s of length %d B doesn't fit in the packet size of %d B", kind, len(b), size)
+	}
+
+	_, err := c.serializer.Write(b)
+	return err
 }
 
 // Flush satisfies the stats.Flusher interface.
 func (c *Client) Flush() {
+	defer func(start time.Time) {
+		atomic.StoreUint64(&c.counters.flushDuration, uint64(time.Since(start)))
+	}(time.Now())
+
+	if c.priority != nil {
+		c.priority.reset(time.Now())
+	}
+
+	if c.canary != nil {
+		now := time.Now()
+		if m := c.canary.measure(now); c.channel != nil {
+			c.channel.handleMeasures(m)
+		} else {
+			c.buffer.HandleMeasures(now, m)
+		}
+	}
+
+	if c.channel != nil {
+		c.channel.flush()
+		return
+	}
+
 	c.buffer.Flush()
+
+	if c.self != nil {
+		c.self.flush(&c.serializer, c.now())
+	}
+}
+
+// Reconfigure applies the options of config which control how metrics are
+// formatted (Namespace, Filters, SignificantFigures, BooleanMetrics, MaxValue,
+// MaxValuePolicy, Separator, TagFormat, UseDistributions, Tags, HostTag, Host,
+// TypeTags, EmitMultiplier, and MeasureFilter) to the running client, keeping
+// its connection. Metrics handled after the method returned are formatted with
+// the new options.
+//
+// The configuration is validated first. The other options of config must be
+// the same as the ones the client was created with, changing them requires
+// creating a new client and Reconfigure returns an error naming the first one
+// that differs. Functions (like DialFunc or ErrorHandler) are compared by
+// identity, closures created by the same function literal cannot be told
+// apart.
+func (c *Client) Reconfigure(config ClientConfig) error {
+	if err := config.Validate(); err != nil {
+		return err
+	}
+
+	config = config.withDefaults()
+
+	if config.Address != c.config.Address {
+		return fmt.Errorf("datadog: cannot change the Address of a running client from %q to %q", c.config.Address, config.Address)
+	}
+
+	if name := restartOption(c.config, config); len(name) != 0 {
+		return fmt.Errorf("datadog: cannot change the %s of a running client", name)
+	}
+
+	if config.Separator != c.format().separator {
+		// Send the metrics buffered with the previous separator first, the
+		// datagrams are split on the separator of the current format.
+		c.Flush()
+	}
+
+	c.setFormat(config)
+	return nil
+}
+
+// restartOption returns the name of the first option which differs between
+// the old and new configurations and cannot be changed on a running client,
+// or an empty string if there is none.
+func restartOption(old, new ClientConfig) string {
+	switch {
+	case new.BufferSize != old.BufferSize:
+		return "BufferSize"
+	case new.Channel != old.Channel:
+		return "Channel"
+	case !sameFunc(new.DialFunc, old.DialFunc):
+		return "DialFunc"
+	case new.SelfMetrics != old.SelfMetrics:
+		return "SelfMetrics"
+	case new.SelfMetricsSampling != old.SelfMetricsSampling:
+		return "SelfMetricsSampling"
+	case new.SelfMetricsHeartbeat != old.SelfMetricsHeartbeat:
+		return "SelfMetricsHeartbeat"
+	case !sameList(new.PriorityMetrics, old.PriorityMetrics):
+		return "PriorityMetrics"
+	case new.PriorityFlushInterval != old.PriorityFlushInterval:
+		return "PriorityFlushInterval"
+	case new.CanaryMetric != old.CanaryMetric:
+		return "CanaryMetric"
+	case !sameList(new.CanaryTags, old.CanaryTags):
+		return "CanaryTags"
+	case new.KeepAlive != old.KeepAlive:
+		return "KeepAlive"
+	case new.MaxReconnects != old.MaxReconnects:
+		return "MaxReconnects"
+	case !sameFunc(new.ErrorHandler, old.ErrorHandler):
+		return "ErrorHandler"
+	case !sameFunc(new.BeforeFlush, old.BeforeFlush):
+		return "BeforeFlush"
+	case !sameFunc(new.AfterFlush, old.AfterFlush):
+		return "AfterFlush"
+	case new.ShutdownRetries != old.ShutdownRetries:
+		return "ShutdownRetries"
+	case new.ShutdownTimeout != old.ShutdownTimeout:
+		return "ShutdownTimeout"
+	}
+	return ""
+}
+
+func sameFunc(a, b interface{}) bool {
+	return reflect.ValueOf(a).Pointer() == reflect.ValueOf(b).Pointer()
+}
+
+func sameList(a, b interface{}) bool {
+	return (reflect.ValueOf(a).Len() == 0 && reflect.ValueOf(b).Len() == 0) || reflect.DeepEqual(a, b)
+}
+
+// SetPacketSize changes the maximum size of datagrams sent by the client to n.
+//
+// The change is picked up at the next flush, a flush that is already in
+// progress completes with the previous size so no pending data is dropped or
+// split in the middle of a metric.
+//
+// The client accumulates batches of metrics up to the BufferSize it was
+// configured with, so the packet size can only be lowered below it, or raised
+// back up to it. An error is returned if n is not positive or exceeds the
+// BufferSize or the limits of the socket, the packet size is left unchanged
+// in that case.
+func (c *Client) SetPacketSize(n int) error {
+	if n <= 0 {
+		return fmt.Errorf("datadog: packet size must be positive, got %d", n)
+	}
+
+	limit := c.buffer.BufferSize
+	if max := int(atomic.LoadInt64(&c.maxBufferSize)); max < limit {
+		limit = max
+	}
+
+	if n > limit {
+		return fmt.Errorf("datadog: packet size of %d B exceeds the limit of %d B of the client", n, limit)
+	}
+
+	atomic.StoreInt64(&c.bufferSize, int64(n))
+	return nil
 }
 
 // Write satisfies the io.Writer interface.
@@ -117,49 +769,215 @@ func (c *Client) Write(b []byte) (int, error) {
 }
 
 // Close flushes and closes the client, satisfies the io.Closer interface.
+// Calling Close again has no effect and returns the error of the first call.
+//
+// Close gives up on the final flush after ShutdownTimeout and returns an
+// error instead of blocking on a connection that stopped accepting writes.
+// When the client is configured with ShutdownRetries, failed writes of the
+// last metrics are retried until the ShutdownTimeout expires.
 func (c *Client) Close() error {
-	c.Flush()
+	ctx, cancel := context.WithTimeout(context.Background(), c.config.ShutdownTimeout)
+	defer cancel()
+	return c.CloseContext(ctx)
+}
+
+// CloseContext flushes and closes the client, giving up on the final flush
+// when ctx is canceled. In that case the connection is closed to interrupt
+// pending writes and the error returned wraps ctx.Err().
+func (c *Client) CloseContext(ctx context.Context) error {
+	c.closeOnce.Do(func() {
+		c.closeErr = c.closeContext(ctx)
+		if c.closed != nil {
+			close(c.closed)
+		}
+	})
+	return c.closeErr
+}
+
+func (c *Client) closeContext(ctx context.Context) error {
+	if c.shutdownRetries != 0 {
+		deadline := time.Now().Add(c.config.ShutdownTimeout)
+		if d, ok := ctx.Deadline(); ok && d.Before(deadline) {
+			deadline = d
+		}
+		atomic.StoreInt64(&c.shutdownDeadline, deadline.UnixNano())
+	}
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		// Priority flushes running in the background are stopped first,
+		// the final flush writes the metrics they would have sent.
+		if c.priority != nil {
+			c.priority.stop()
+		}
+		c.Flush()
+	}()
+
+	select {
+	case <-done:
+	case <-ctx.Done():
+		c.close()
+		return fmt.Errorf("datadog: gave up flushing metrics while closing the client: %w", ctx.Err())
+	}
+
 	c.close()
-	return c.err
+	return c.dialError()
 }
 
 type serializer struct {
-	conn       net.Conn
-	bufferSize int
-	filters    map[string]struct{}
+	bufferSize       int64 // accessed atomically, must be 64 bits aligned
+	shutdownDeadline int64 // unix time in nanoseconds, zero until the client is closed
+	maxBufferSize    int64 // limit of the socket, accessed atomically
+	counters         counters
+	conn             atomic.Value // connection
+	dialErr          atomic.Value // dialResult
+	options          atomic.Value // *format
+	reconnector      *reconnector
+	shutdownRetries  int
+	beforeFlush      func(io.Writer)
+	afterFlush       func(io.Writer)
+	errorHandler     func(error)
 }
 
 func (s *serializer) AppendMeasures(b []byte, _ time.Time, measures ...stats.Measure) []byte {
+	f := s.format()
+
 	for _, m := range measures {
-		b = AppendMeasureFiltered(b, m, s.filters)
+		if f.measureFilter != nil {
+			var ok bool
+			if m, ok = f.measureFilter(m); !ok {
+				continue
+			}
+		}
+
+		if f.emitMultiplier != 0 && f.emitMultiplier != 1 {
+			b = f.appendMeasureMultiplied(b, m)
+		} else {
+			b = f.appendMeasure(b, m)
+		}
 	}
 	return b
 }
 
+// format returns the options currently used to serialize measures.
+func (s *serializer) format() *format {
+	if f, _ := s.options.Load().(*format); f != nil {
+		return f
+	}
+	return &format{}
+}
+
+// setFormat builds the serialization options from config and makes them the
+// ones used by the serializer. The config defaults must have been applied.
+func (s *serializer) setFormat(config ClientConfig) {
+	// transform filters from array to map
+	filterMap := make(map[string]struct{})
+	for _, f := range config.Filters {
+		filterMap[f] = struct{}{}
+	}
+
+	tags := config.Tags
+
+	if config.HostTag {
+		host := config.Host
+		if len(host) == 0 {
+			host, _ = os.Hostname()
+		}
+		tags = append(tags[:len(tags):len(tags)], stats.T("host", host))
+	}
+
+	f := &format{
+		namespace:          config.Namespace,
+		filters:            filterMap,
+		tags:               tags,
+		significantFigures: config.SignificantFigures,
+		maxValue:           math.Abs(config.MaxValue),
+		maxValuePolicy:     config.MaxValuePolicy,
+		separator:          config.Separator,
+		tagFormat:          config.TagFormat,
+		typeTags:           config.TypeTags,
+		emitMultiplier:     config.EmitMultiplier,
+		measureFilter:      config.MeasureFilter,
+		distributions:      config.UseDistributions,
+		counters:           &s.counters,
+		countMetrics:       true,
+	}
+
+	if len(config.BooleanMetrics) != 0 {
+		f.booleans = make(map[string]struct{}, len(config.BooleanMetrics))
+		for _, name := range config.BooleanMetrics {
+			f.booleans[name] = struct{}{}
+		}
+	}
+
+	s.options.Store(f)
+}
+
 func (s *serializer) Write(b []byte) (int, error) {
-	if s.conn == nil {
+	conn := s.connection()
+
+	if conn == nil && s.reconnector != nil {
+		conn = s.reconnect(nil)
+	}
+
+	if conn == nil {
 		return 0, io.ErrClosedPipe
 	}
 
-	if len(b) <= s.bufferSize {
-		return s.conn.Write(b)
+	// Flushing empty buffers would send empty datagrams, which unix datagram
+	// sockets deliver to the agent.
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	if s.beforeFlush == nil && s.afterFlush == nil {
+		return s.writeBatch(conn, b)
+	}
+
+	if s.beforeFlush != nil {
+		s.beforeFlush(conn)
+	}
+
+	n, err := s.writeBatch(conn, b)
+
+	if s.afterFlush != nil {
+		s.afterFlush(conn)
+	}
+
+	return n, err
+}
+
+func (s *serializer) writeBatch(conn net.Conn, b []byte) (int, error) {
+	// Load the buffer size once so a concurrent call to SetPacketSize only
+	// takes effect at the next flush.
+	bufferSize := int(atomic.LoadInt64(&s.bufferSize))
+
+	if len(b) <= bufferSize {
+		return s.write(conn, b)
 	}
 
 	// When the serialized metrics are larger than the configured socket buffer
-	// size we split them on '\n' characters.
+	// size we split them on separator characters.
 	var n int
+	sep := s.format().lineSeparator()
 
 	for len(b) != 0 {
 		var splitIndex int
 
 		for splitIndex != len(b) {
-			i := bytes.IndexByte(b[splitIndex:], '\n')
+			i := bytes.IndexByte(b[splitIndex:], sep)
 			if i < 0 {
-				panic("stats/datadog: metrics are not formatted for the dogstatsd protocol")
+				// The end of the batch was not serialized with the current
+				// separator, which happens when Reconfigure changed it while
+				// metrics were buffered. The remaining bytes are handled as a
+				// single metric, which is dropped if it doesn't fit.
+				i = len(b) - splitIndex - 1
 			}
-			if (i + splitIndex) >= s.bufferSize {
+			if (i + splitIndex) >= bufferSize {
 				if splitIndex == 0 {
-					log.Printf("stats/datadog: metric of length %d B doesn't fit in the socket buffer of size %d B: %s", i+1, s.bufferSize, string(b))
+					atomic.AddUint64(&s.counters.oversizedMetrics, 1)
+					s.handleError(fmt.Errorf("datadog: metric of length %d B doesn't fit in the packet size of %d B", i+1, bufferSize))
 					b = b[i+1:]
 					continue
 				}
@@ -168,7 +986,7 @@ func (s *serializer) Write(b []byte) (int, error) {
 			splitIndex += i + 1
 		}
 
-		c, err := s.conn.Write(b[:splitIndex])
+		c, err := s.write(conn, b[:splitIndex])
 		if err != nil {
 			return n + c, err
 		}
@@ -180,20 +998,153 @@ func (s *serializer) Write(b []byte) (int, error) {
 	return n, nil
 }
 
+// write sends a single datagram. When the client is shutting down, failed
+// writes are retried with an exponential backoff until the retries are
+// exhausted or the shutdown deadline is reached.
+//
+// Clients configured with MaxReconnects replace their connection when writes
+// keep failing.
+func (s *serializer) write(conn net.Conn, b []byte) (int, error) {
+	n, err := conn.Write(b)
+
+	// Writes to unix datagram sockets fail with ENOBUFS while the agent is
+	// not keeping up, the condition is transient so the write is retried a
+	// few times before the datagram is dropped.
+	for attempt := 0; attempt != 3 && errors.Is(err, syscall.ENOBUFS); attempt++ {
+		time.Sleep(time.Millisecond)
+		n, err = conn.Write(b)
+	}
+
+	if err != nil {
+		if deadline := atomic.LoadInt64(&s.shutdownDeadline); deadline != 0 {
+			delay := 10 * time.Millisecond
+
+			for attempt := 0; attempt != s.shutdownRetries; attempt++ {
+				if time.Now().Add(delay).UnixNano() > deadline {
+					break
+				}
+				time.Sleep(delay)
+				delay *= 2
+
+				if n, err = conn.Write(b); err == nil {
+					break
+				}
+			}
+		}
+	}
+
+	if err == nil {
+		atomic.AddUint64(&s.counters.datagrams, 1)
+		atomic.AddUint64(&s.counters.bytes, uint64(n))
+	} else {
+		atomic.AddUint64(&s.counters.writeErrors, 1)
+
+		if s.errorHandler != nil {
+			s.errorHandler(fmt.Errorf("datadog: writing metrics: %w", err))
+		}
+	}
+
+	if s.reconnector != nil && s.reconnector.record(err) {
+		s.reconnect(conn)
+	}
+
+	return n, err
+}
+
+// handleError reports err to the ErrorHandler of the client, or logs it if the
+// client has none.
+func (s *serializer) handleError(err error) {
+	if s.errorHandler != nil {
+		s.errorHandler(err)
+	} else {
+		logError(err)
+	}
+}
+
+// splitNetworkAddress splits the network from addresses prefixed with a scheme,
+// like unixgram:///var/run/datadog/dsd.socket. The network defaults to udp.
+func splitNetworkAddress(address string) (network string, addr string) {
+	if i := strings.Index(address, "://"); i >= 0 {
+		if network, addr = address[:i], address[i+3:]; network == "unix" {
+			// Metrics are sent one datagram at a time, unix stream sockets
+			// are not supported.
+			network = "unixgram"
+		}
+		return
+	}
+	return "udp", address
+}
+
 func (s *serializer) close() {
-	if s.conn != nil {
-		s.conn.Close()
+	if s.reconnector != nil {
+		s.reconnector.mutex.Lock()
+		s.reconnector.closed = true
+		s.reconnector.mutex.Unlock()
+	}
+
+	if conn := s.connection(); conn != nil {
+		conn.Close()
 	}
 }
 
-func dial(address string, sizehint int) (conn net.Conn, bufsize int, err error) {
+// logError logs err with the standard logger, errors of the package are
+// prefixed with "datadog: " so they keep the stats/datadog prefix of the other
+// messages it logs.
+func logError(err error) {
+	log.Printf("stats/%s", err)
+}
+
+// dialError returns a dial function which always fails with err, it is used to
+// create clients that discard all metrics and report err when they are closed.
+func dialError(err error) func(string, string) (net.Conn, error) {
+	return func(string, string) (net.Conn, error) { return nil, err }
+}
+
+// keepAliveConn is implemented by connections supporting keep-alives, like
+// *net.TCPConn.
+type keepAliveConn interface {
+	SetKeepAlive(bool) error
+	SetKeepAlivePeriod(time.Duration) error
+}
+
+func dial(dialFunc func(string, string) (net.Conn, error), address string, sizehint int, keepAlive time.Duration) (conn net.Conn, bufsize int, err error) {
 	var f *os.File
 
-	if conn, err = net.Dial("udp", address); err != nil {
+	network, address := splitNetworkAddress(address)
+
+	if conn, err = dialFunc(network, address); err != nil {
+		return
+	}
+
+	if c, ok := conn.(keepAliveConn); ok {
+		if keepAlive < 0 {
+			err = c.SetKeepAlive(false)
+		} else if err = c.SetKeepAlive(true); err == nil {
+			err = c.SetKeepAlivePeriod(keepAlive)
+		}
+		if err != nil {
+			conn.Close()
+			return
+		}
+	}
+
+	var sock interface {
+		File() (*os.File, error)
+	}
+
+	switch c := conn.(type) {
+	case *net.UDPConn:
+		sock = c
+	case *net.UnixConn:
+		sock = c
+	default:
+		// The socket buffer of custom connections cannot be tuned, the size
+		// hint is the only limit that the client can rely on.
+		bufsize = MaxBufferSize
 		return
 	}
 
-	if f, err = conn.(*net.UDPConn).File(); err != nil {
+	if f, err = sock.File(); err != nil {
 		conn.Close()
 		return
 	}
@@ -230,16 +1181,6 @@ func dial(address string, sizehint int) (conn net.Conn, bufsize int, err error)
 		bufsize = MaxBufferSize
 	}
 
-	// Use the size hint as an upper bound, event if the socket buffer is
-	// larger, this gives control in situations where the receive buffer size
-	// on the other side is known but cannot be controlled so the client does
-	// not produce datagrams that are too large for the receiver.
-	//
-	// Related issue: https://github.com/DataDog/dd-agent/issues/2638
-	if bufsize > sizehint {
-		bufsize = sizehint
-	}
-
 	// Creating the file put the socket in blocking mode, reverting.
 	syscall.SetNonblock(fd, true)
 	return
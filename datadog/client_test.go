@@ -1,12 +1,20 @@
 package datadog
 
 import (
+	"bytes"
+	"context"
+	"errors"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"log"
 	"net"
+	"os"
+	"path/filepath"
 	"strings"
+	"sync"
 	"sync/atomic"
+	"syscall"
 	"testing"
 	"time"
 
@@ -69,6 +77,1500 @@ main.http.rtt.seconds:0.001215296|h|#http_req_content_charset:,http_req_content_
 	}
 }
 
+func TestClientSetPacketSize(t *testing.T) {
+	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:    conn.LocalAddr().String(),
+		BufferSize: 512,
+	})
+	defer client.Close()
+
+	line := "test.metric:1|c|#hello:world\n" // 29 bytes
+	data := []byte(strings.Repeat(line, 16))
+
+	readPackets := func(total int) (sizes []int) {
+		b := make([]byte, 65536)
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+
+		for n := 0; n < total; {
+			size, _, err := conn.ReadFrom(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+			sizes = append(sizes, size)
+			n += size
+		}
+		return
+	}
+
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	if sizes := readPackets(len(data)); len(sizes) != 1 {
+		t.Error("expected a single datagram before resizing, got:", sizes)
+	}
+
+	if err := client.SetPacketSize(100); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := client.Write(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for _, size := range readPackets(len(data)) {
+		if size > 100 || size%len(line) != 0 {
+			t.Error("bad datagram size after resizing:", size)
+		}
+	}
+
+	for _, n := range []int{0, 513} {
+		if err := client.SetPacketSize(n); err == nil {
+			t.Errorf("no error returned for a packet size of %d B", n)
+		}
+	}
+
+	if size := client.Stats().PacketSize; size != 100 {
+		t.Error("the packet size was changed by an invalid value:", size)
+	}
+}
+
+func TestClientEmitMultiplier(t *testing.T) {
+	client := &Client{}
+	client.setFormat(ClientConfig{EmitMultiplier: 3})
+
+	b := client.AppendMeasures(nil, time.Time{}, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		Tags:   []stats.Tag{stats.T("hello", "world")},
+	})
+
+	const expect = `request.count:1|c|#hello:world,emit_copy:0
+request.count:1|c|#hello:world,emit_copy:1
+request.count:1|c|#hello:world,emit_copy:2
+`
+
+	if s := string(b); s != expect {
+		t.Error("bad metrics:")
+		t.Log("expected:", expect)
+		t.Log("found:   ", s)
+	}
+}
+
+func TestClientContext(t *testing.T) {
+	conn := &notifyingConn{done: make(chan struct{})}
+
+	ctx, cancel := context.WithCancel(context.Background())
+	client := NewClientContext(ctx, ClientConfig{
+		DialFunc: func(string, string) (net.Conn, error) { return conn, nil },
+	})
+	engine := stats.NewEngine("datadog.test", client)
+
+	engine.Incr("A")
+	engine.Incr("B")
+
+	// The client writes metrics synchronously when it flushes, so metrics
+	// flushed before the context is canceled would already be written.
+	if conn.writes != 0 {
+		t.Error("metrics were flushed before the context was canceled:", conn.output.String())
+	}
+
+	cancel()
+
+	select {
+	case <-conn.done:
+	case <-time.After(time.Second):
+		t.Fatal("timeout waiting for the client to be closed")
+	}
+
+	// The order of the metrics depends on the buffer they were written to.
+	b := conn.output.String()
+
+	if !strings.Contains(b, "datadog.test.A:1|c\n") || !strings.Contains(b, "datadog.test.B:1|c\n") || len(b) != 38 {
+		t.Errorf("bad output after the context was canceled: %q", b)
+	}
+}
+
+func TestClientContextClosed(t *testing.T) {
+	conn := &flakyConn{}
+
+	client := NewClientContext(context.Background(), ClientConfig{
+		DialFunc: func(string, string) (net.Conn, error) { return conn, nil },
+	})
+
+	// Closing the client stops the goroutine waiting for the context.
+	if err := client.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case <-client.closed:
+	default:
+		t.Error("the client was not marked as closed")
+	}
+
+	// Closing the client again does not flush it to the closed connection.
+	writes := conn.writes
+	client.HandleMeasures(time.Now(), stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})
+
+	if err := client.Close(); err != nil {
+		t.Error("closing the client twice failed:", err)
+	}
+
+	if conn.writes != writes {
+		t.Error("the client was flushed after it was closed:", conn.output.String())
+	}
+}
+
+func TestClientCloseError(t *testing.T) {
+	client := NewClientWith(ClientConfig{
+		Address:      "localhost:8125",
+		BufferSize:   -1,
+		ErrorHandler: func(error) {},
+	})
+
+	err1 := client.Close()
+	err2 := client.Close()
+
+	if err1 == nil || err1 != err2 {
+		t.Errorf("closing the client twice must return the first error: %v, %v", err1, err2)
+	}
+}
+
+func TestClientSelfMetricsAlive(t *testing.T) {
+	values := make(chan float64, 10)
+
+	addr, closer := startTestServer(t, HandlerFunc(func(m Metric, _ net.Addr) {
+		if m.Name == "stats.client.alive" {
+			values <- m.Value
+		}
+	}))
+	defer closer.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:     addr,
+		SelfMetrics: true,
+	})
+	defer client.Close()
+
+	now := time.Unix(1500000000, 0)
+	client.now = func() time.Time { return now }
+
+	readValue := func() float64 {
+		select {
+		case v := <-values:
+			return v
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for the alive metric")
+			return 0
+		}
+	}
+
+	client.Flush()
+	v1 := readValue()
+
+	now = now.Add(10 * time.Millisecond)
+
+	client.Flush()
+	v2 := readValue()
+
+	if v1 != 1500000000 {
+		t.Error("the alive metric is not the unix time of the flush:", v1)
+	}
+
+	if v2 != 1500000000.01 {
+		t.Error("the alive metric did not advance across flushes:", v1, v2)
+	}
+}
+
+func TestClientSelfMetricsSampling(t *testing.T) {
+	local, remote := net.Pipe()
+
+	client := NewClientWith(ClientConfig{
+		BooleanMetrics:      []string{"datadog.test.up"},
+		SelfMetrics:         true,
+		SelfMetricsSampling: 3,
+		DialFunc:            func(string, string) (net.Conn, error) { return local, nil },
+	})
+
+	output := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(remote)
+		output <- b
+	}()
+
+	engine := stats.NewEngine("datadog.test", client)
+
+	for i := 0; i != 7; i++ {
+		engine.Set("up", 2)
+		client.Flush()
+	}
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	// Closing the client was the 8th flush, the self metrics must have been
+	// emitted on the 3rd and 6th flushes only, with the violations that
+	// occurred since the previous emission.
+	b := string(<-output)
+
+	if n := strings.Count(b, "stats.client.alive:"); n != 2 {
+		t.Errorf("self metrics emitted %d times instead of 2:\n%s", n, b)
+	}
+
+	if n := strings.Count(b, "stats.client.boolean_violations:3|c\n"); n != 2 {
+		t.Errorf("bad accumulated counters:\n%s", b)
+	}
+}
+
+func TestClientSelfMetricsHeartbeat(t *testing.T) {
+	local, remote := net.Pipe()
+
+	client := NewClientWith(ClientConfig{
+		SelfMetrics:          true,
+		SelfMetricsHeartbeat: "datadog.test.heartbeat",
+		DialFunc:             func(string, string) (net.Conn, error) { return local, nil },
+	})
+
+	output := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(remote)
+		output <- b
+	}()
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Incr("A")
+	engine.Incr("B")
+	engine.Observe("C", 1)
+	client.Flush()
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	b := string(<-output)
+
+	for _, metric := range []string{
+		"datadog.test.heartbeat:3|g\n", // the first flush wrote 3 metrics
+		"datadog.test.heartbeat:0|g\n", // the client was idle when it was closed
+	} {
+		if !strings.Contains(b, metric) {
+			t.Errorf("metric %q not found in %q", metric, b)
+		}
+	}
+}
+
+func TestClientCanary(t *testing.T) {
+	channel := make(chan []stats.Measure, 1)
+
+	client := NewClientWith(ClientConfig{
+		Channel:      channel,
+		CanaryMetric: "datadog.test.canary",
+		CanaryTags:   []stats.Tag{stats.T("canary_id", "1234")},
+	})
+	defer client.Close()
+
+	if last := client.Stats().CanaryTime; !last.IsZero() {
+		t.Error("canary reported as emitted before the first flush:", last)
+	}
+
+	before := time.Now()
+	client.Flush()
+	after := time.Now()
+
+	measures := <-channel
+	if len(measures) != 1 {
+		t.Fatal("bad number of measures:", measures)
+	}
+
+	m := measures[0]
+	if m.Name != "datadog.test.canary" || len(m.Tags) != 1 || m.Tags[0] != stats.T("canary_id", "1234") {
+		t.Error("bad canary:", m)
+	}
+
+	last := client.Stats().CanaryTime
+	if last.Before(before) || last.After(after) {
+		t.Errorf("bad canary time: %s not in [%s, %s]", last, before, after)
+	}
+
+	if v := m.Fields[0].Value.Float(); v != float64(last.UnixNano())/1e9 {
+		t.Errorf("the canary value doesn't match its emission time: %g != %s", v, last)
+	}
+}
+
+func TestClientDialFunc(t *testing.T) {
+	local, remote := net.Pipe()
+
+	client := NewClientWith(ClientConfig{
+		Address: "in-memory",
+		DialFunc: func(network, address string) (net.Conn, error) {
+			if network != "udp" || address != "in-memory" {
+				t.Errorf("bad dial arguments: %s %s", network, address)
+			}
+			return local, nil
+		},
+	})
+
+	output := make(chan []byte)
+	go func() {
+		b, _ := ioutil.ReadAll(remote)
+		output <- b
+	}()
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Incr("A")
+	engine.Set("B", 42)
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	b := <-output
+
+	for _, metric := range []string{"datadog.test.A:1|c\n", "datadog.test.B:42|g\n"} {
+		if !strings.Contains(string(b), metric) {
+			t.Errorf("metric %q not found in %q", metric, b)
+		}
+	}
+}
+
+func TestClientSeparator(t *testing.T) {
+	tests := []struct {
+		scenario  string
+		separator byte
+		output    string
+	}{
+		{
+			scenario: "default separator",
+			output:   "datadog.test.A:1|c\ndatadog.test.B:2|c\ndatadog.test.C:3|c\n",
+		},
+		{
+			scenario:  "custom separator",
+			separator: '\r',
+			output:    "datadog.test.A:1|c\rdatadog.test.B:2|c\rdatadog.test.C:3|c\r",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			local, remote := net.Pipe()
+
+			client := NewClientWith(ClientConfig{
+				Separator: test.separator,
+				DialFunc:  func(string, string) (net.Conn, error) { return local, nil },
+			})
+
+			output := make(chan []byte)
+			go func() {
+				b, _ := ioutil.ReadAll(remote)
+				output <- b
+			}()
+
+			// All metrics are sent in a single call so they end up in the same
+			// datagram.
+			client.HandleMeasures(time.Now(),
+				stats.Measure{Name: "datadog.test", Fields: []stats.Field{stats.MakeField("A", 1, stats.Counter)}},
+				stats.Measure{Name: "datadog.test", Fields: []stats.Field{stats.MakeField("B", 2, stats.Counter)}},
+				stats.Measure{Name: "datadog.test", Fields: []stats.Field{stats.MakeField("C", 3, stats.Counter)}},
+			)
+
+			if err := client.Close(); err != nil {
+				t.Error(err)
+			}
+
+			if b := string(<-output); b != test.output {
+				t.Errorf("bad datagram:\nwant: %q\ngot:  %q", test.output, b)
+			}
+		})
+	}
+}
+
+func TestClientFlushHooks(t *testing.T) {
+	conn := &flakyConn{}
+
+	client := NewClientWith(ClientConfig{
+		DialFunc:    func(string, string) (net.Conn, error) { return conn, nil },
+		BeforeFlush: func(w io.Writer) { io.WriteString(w, "BEGIN\n") },
+		AfterFlush:  func(w io.Writer) { io.WriteString(w, "END\n") },
+	})
+
+	client.HandleMeasures(time.Now(),
+		stats.Measure{Name: "datadog.test", Fields: []stats.Field{stats.MakeField("A", 1, stats.Counter)}},
+		stats.Measure{Name: "datadog.test", Fields: []stats.Field{stats.MakeField("B", 42, stats.Gauge)}},
+	)
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	const output = "BEGIN\ndatadog.test.A:1|c\ndatadog.test.B:42|g\nEND\n"
+
+	if b := conn.output.String(); b != output {
+		t.Errorf("bad output:\nwant: %q\ngot:  %q", output, b)
+	}
+}
+
+func TestClientReconfigure(t *testing.T) {
+	conn := &flakyConn{}
+	dial := func(string, string) (net.Conn, error) { return conn, nil }
+
+	client := NewClientWith(ClientConfig{
+		DialFunc: dial,
+	})
+
+	counter := stats.Measure{
+		Name:   "datadog.test",
+		Fields: []stats.Field{stats.MakeField("A", 1, stats.Counter)},
+	}
+
+	client.HandleMeasures(time.Now(), counter)
+	client.Flush()
+
+	if err := client.Reconfigure(ClientConfig{
+		DialFunc: dial,
+		TypeTags: map[stats.FieldType][]stats.Tag{
+			stats.Counter: {stats.T("hello", "world")},
+		},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client.HandleMeasures(time.Now(), counter)
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	const output = "datadog.test.A:1|c\ndatadog.test.A:1|c|#hello:world\n"
+
+	if b := conn.output.String(); b != output {
+		t.Errorf("bad output:\nwant: %q\ngot:  %q", output, b)
+	}
+}
+
+func TestClientReconfigureErrors(t *testing.T) {
+	dial := func(string, string) (net.Conn, error) { return &flakyConn{}, nil }
+
+	client := NewClientWith(ClientConfig{
+		DialFunc:      dial,
+		MaxReconnects: 1,
+	})
+	defer client.Close()
+
+	tests := []struct {
+		scenario string
+		config   ClientConfig
+		err      string
+	}{
+		{
+			scenario: "invalid configuration",
+			config:   ClientConfig{DialFunc: dial, MaxReconnects: 1, SignificantFigures: -1},
+			err:      "datadog: SignificantFigures must not be negative, got -1",
+		},
+		{
+			scenario: "changing the address",
+			config:   ClientConfig{DialFunc: dial, MaxReconnects: 1, Address: "localhost:8126"},
+			err:      `datadog: cannot change the Address of a running client from "localhost:8125" to "localhost:8126"`,
+		},
+		{
+			scenario: "changing the channel",
+			config:   ClientConfig{Channel: make(chan []stats.Measure)},
+			err:      "datadog: cannot change the Channel of a running client",
+		},
+		{
+			scenario: "changing the dial function",
+			config:   ClientConfig{MaxReconnects: 1},
+			err:      "datadog: cannot change the DialFunc of a running client",
+		},
+		{
+			scenario: "changing the buffer size",
+			config:   ClientConfig{DialFunc: dial, MaxReconnects: 1, BufferSize: 512},
+			err:      "datadog: cannot change the BufferSize of a running client",
+		},
+		{
+			scenario: "changing the number of reconnections",
+			config:   ClientConfig{DialFunc: dial},
+			err:      "datadog: cannot change the MaxReconnects of a running client",
+		},
+		{
+			scenario: "changing the error handler",
+			config:   ClientConfig{DialFunc: dial, MaxReconnects: 1, ErrorHandler: func(error) {}},
+			err:      "datadog: cannot change the ErrorHandler of a running client",
+		},
+		{
+			scenario: "enabling self metrics",
+			config:   ClientConfig{DialFunc: dial, MaxReconnects: 1, SelfMetrics: true},
+			err:      "datadog: cannot change the SelfMetrics of a running client",
+		},
+		{
+			scenario: "changing the shutdown timeout",
+			config:   ClientConfig{DialFunc: dial, MaxReconnects: 1, ShutdownTimeout: time.Minute},
+			err:      "datadog: cannot change the ShutdownTimeout of a running client",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			if err := client.Reconfigure(test.config); err == nil || err.Error() != test.err {
+				t.Error("bad error:", err)
+			}
+		})
+	}
+
+	t.Run("setting the defaults explicitly", func(t *testing.T) {
+		if err := client.Reconfigure(ClientConfig{
+			Address:         DefaultAddress,
+			BufferSize:      DefaultBufferSize,
+			DialFunc:        dial,
+			MaxReconnects:   1,
+			ShutdownTimeout: DefaultShutdownTimeout,
+		}); err != nil {
+			t.Error(err)
+		}
+	})
+}
+
+func TestClientReconfigureSeparator(t *testing.T) {
+	conn := &flakyConn{}
+	dial := func(string, string) (net.Conn, error) { return conn, nil }
+
+	client := NewClientWith(ClientConfig{
+		BufferSize:   16,
+		DialFunc:     dial,
+		ErrorHandler: func(error) {},
+	})
+	defer client.Close()
+
+	counter := func(name string) stats.Measure {
+		return stats.Measure{Name: name, Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)}}
+	}
+
+	client.HandleMeasures(time.Now(), counter("A"), counter("B"))
+
+	if err := client.Reconfigure(ClientConfig{
+		BufferSize:   16,
+		DialFunc:     dial,
+		ErrorHandler: client.config.ErrorHandler,
+		Separator:    '\r',
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	client.HandleMeasures(time.Now(), counter("C"), counter("D"))
+	client.Flush()
+
+	const output = "A:1|c\nB:1|c\nC:1|c\rD:1|c\r"
+
+	if b := conn.output.String(); b != output {
+		t.Errorf("bad output:\nwant: %q\ngot:  %q", output, b)
+	}
+
+	// Batches which were not serialized with the current separator don't
+	// make the client panic, they are dropped if they don't fit in a
+	// datagram.
+	if _, err := client.Write([]byte("E:1|c\nF:1|c\nG:1|c\n")); err != nil {
+		t.Error(err)
+	}
+
+	if n := client.Stats().OversizedMetrics; n != 1 {
+		t.Error("bad number of oversized metrics:", n)
+	}
+}
+
+func TestClientReconnect(t *testing.T) {
+	broken := &flakyConn{failures: -1, err: syscall.ECONNREFUSED}
+	healthy := &flakyConn{}
+	conns := []net.Conn{broken, healthy}
+
+	client := NewClientWith(ClientConfig{
+		MaxReconnects: 3,
+		DialFunc: func(string, string) (net.Conn, error) {
+			conn := conns[0]
+			conns = conns[1:]
+			return conn, nil
+		},
+	})
+
+	engine := stats.NewEngine("datadog.test", client)
+
+	for i := 0; i != reconnectThreshold+1; i++ {
+		engine.Incr("A")
+		client.Flush()
+	}
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if len(conns) != 0 {
+		t.Error("the client did not reconnect")
+	}
+
+	if !broken.closed {
+		t.Error("the broken connection was not closed")
+	}
+
+	if b := healthy.output.String(); b != "datadog.test.A:1|c\n" {
+		t.Errorf("bad output after reconnecting: %q", b)
+	}
+}
+
+func TestClientReconnectDialError(t *testing.T) {
+	healthy := &flakyConn{}
+	dials := 0
+
+	client := NewClientWith(ClientConfig{
+		MaxReconnects: 2,
+		DialFunc: func(string, string) (net.Conn, error) {
+			if dials++; dials == 1 {
+				return nil, syscall.ECONNREFUSED
+			}
+			return healthy, nil
+		},
+	})
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Incr("A")
+	client.Flush()
+
+	if err := client.Stats().Error; len(err) != 0 {
+		t.Error("the dial error is still reported after reconnecting:", err)
+	}
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if b := healthy.output.String(); b != "datadog.test.A:1|c\n" {
+		t.Errorf("bad output after reconnecting: %q", b)
+	}
+}
+
+func TestClientReconnectSocketLimits(t *testing.T) {
+	client := NewClientWith(ClientConfig{
+		MaxReconnects: 1,
+		DialFunc: func(string, string) (net.Conn, error) {
+			return nil, syscall.ECONNREFUSED
+		},
+		ErrorHandler: func(error) {},
+	})
+	defer client.Close()
+
+	conn := &flakyConn{}
+	client.reconnector.dial = func() (net.Conn, int, error) { return conn, 16, nil }
+
+	client.HandleMeasures(time.Now(),
+		stats.Measure{Name: "A", Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)}},
+		stats.Measure{Name: "B", Fields: []stats.Field{stats.MakeField("", 2, stats.Counter)}},
+	)
+	client.Flush()
+
+	if size := client.Stats().PacketSize; size != 16 {
+		t.Error("the socket limits were not applied after reconnecting:", size)
+	}
+
+	if err := client.SetPacketSize(DefaultBufferSize); err == nil {
+		t.Error("no error returned for a packet size exceeding the socket limits")
+	}
+}
+
+func TestClientReconnectGivesUp(t *testing.T) {
+	dials := 0
+	gaveUp := false
+
+	client := NewClientWith(ClientConfig{
+		MaxReconnects: 2,
+		DialFunc: func(string, string) (net.Conn, error) {
+			dials++
+			return nil, syscall.ECONNREFUSED
+		},
+		ErrorHandler: func(err error) {
+			gaveUp = gaveUp || strings.HasPrefix(err.Error(), "datadog: giving up reconnecting")
+		},
+	})
+
+	engine := stats.NewEngine("datadog.test", client)
+
+	// Attempts are spaced by 100ms then 200ms, flushes are repeated until the
+	// client gives up.
+	for deadline := time.Now().Add(5 * time.Second); !gaveUp; {
+		if time.Now().After(deadline) {
+			t.Fatal("timeout waiting for the client to give up reconnecting")
+		}
+		engine.Incr("A")
+		client.Flush()
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	// The delay before the next attempt is cleared so the client would dial
+	// again if it had not given up.
+	client.reconnector.mutex.Lock()
+	client.reconnector.next = time.Time{}
+	client.reconnector.mutex.Unlock()
+
+	engine.Incr("A")
+	client.Flush()
+	client.Close()
+
+	if dials != 3 {
+		t.Error("bad number of dials:", dials)
+	}
+}
+
+func TestClientHostTag(t *testing.T) {
+	hostname, _ := os.Hostname()
+
+	tests := []struct {
+		scenario string
+		host     string
+		tag      stats.Tag
+	}{
+		{
+			scenario: "the host tag defaults to the hostname",
+			tag:      stats.T("host", hostname),
+		},
+		{
+			scenario: "the host tag is configurable",
+			host:     "example.com",
+			tag:      stats.T("host", "example.com"),
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			client := &Client{}
+			client.setFormat(ClientConfig{
+				Tags:    []stats.Tag{stats.T("env", "test")},
+				HostTag: true,
+				Host:    test.host,
+			})
+
+			b := client.AppendMeasures(nil, time.Time{}, stats.Measure{
+				Name:   "request",
+				Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+			})
+
+			if s, expect := string(b), "request.count:1|c|#env:test,host:"+test.tag.Value+"\n"; s != expect {
+				t.Errorf("bad metric:\nwant: %q\ngot:  %q", expect, s)
+			}
+		})
+	}
+}
+
+func TestClientMeasureFilter(t *testing.T) {
+	client := &Client{}
+	client.setFormat(ClientConfig{
+		MeasureFilter: func(m stats.Measure) (stats.Measure, bool) {
+			if strings.HasPrefix(m.Name, "debug.") {
+				return m, false
+			}
+			if m.Name == "legacy" {
+				m.Name = "request"
+			}
+			return m, true
+		},
+	})
+
+	b := client.AppendMeasures(nil, time.Time{},
+		stats.Measure{
+			Name:   "debug.allocs",
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		},
+		stats.Measure{
+			Name:   "legacy",
+			Fields: []stats.Field{stats.MakeField("count", 2, stats.Counter)},
+		},
+	)
+
+	if s, expect := string(b), "request.count:2|c\n"; s != expect {
+		t.Errorf("bad metrics:\nwant: %q\ngot:  %q", expect, s)
+	}
+}
+
+func TestClientUseDistributions(t *testing.T) {
+	metrics := make(chan Metric, 1)
+
+	addr, closer := startTestServer(t, HandlerFunc(func(m Metric, _ net.Addr) {
+		metrics <- m
+	}))
+	defer closer.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:          addr,
+		UseDistributions: true,
+	})
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Observe("rtt", 42)
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	select {
+	case m := <-metrics:
+		if m.Type != Distribution || m.Name != "datadog.test.rtt" || m.Value != 42 {
+			t.Error("bad metric:", m)
+		}
+	case <-time.After(time.Second):
+		t.Error("timeout waiting for the distribution")
+	}
+}
+
+func TestClientSets(t *testing.T) {
+	metrics := make(chan Metric, 2)
+
+	addr, closer := startTestServer(t, HandlerFunc(func(m Metric, _ net.Addr) {
+		metrics <- m
+	}))
+	defer closer.Close()
+
+	client := NewClientWith(ClientConfig{Address: addr})
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Unique("users", 42)
+	engine.Unique("users", 43)
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	values := map[float64]bool{}
+
+	for i := 0; i != 2; i++ {
+		select {
+		case m := <-metrics:
+			if m.Type != Set || m.Name != "datadog.test.users" {
+				t.Error("bad metric:", m)
+			}
+			values[m.Value] = true
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for the set")
+		}
+	}
+
+	if !values[42] || !values[43] {
+		t.Error("bad set values:", values)
+	}
+}
+
+func TestClientErrorHandler(t *testing.T) {
+	t.Run("dial errors are reported", func(t *testing.T) {
+		var errs []error
+
+		client := NewClientWith(ClientConfig{
+			DialFunc:     func(string, string) (net.Conn, error) { return nil, syscall.ECONNREFUSED },
+			ErrorHandler: func(err error) { errs = append(errs, err) },
+		})
+		client.Close()
+
+		if len(errs) != 1 || !errors.Is(errs[0], syscall.ECONNREFUSED) || errs[0].Error() != "datadog: dialing localhost:8125: connection refused" {
+			t.Error("bad errors:", errs)
+		}
+	})
+
+	t.Run("validation errors are reported", func(t *testing.T) {
+		var errs []error
+
+		client := NewClientWith(ClientConfig{
+			SignificantFigures: -1,
+			ErrorHandler:       func(err error) { errs = append(errs, err) },
+		})
+		client.Close()
+
+		if len(errs) != 1 || errs[0].Error() != "datadog: SignificantFigures must not be negative, got -1" {
+			t.Error("bad errors:", errs)
+		}
+	})
+
+	t.Run("write errors are reported", func(t *testing.T) {
+		var errs []error
+		conn := &flakyConn{failures: 1, err: syscall.ECONNREFUSED}
+
+		client := NewClientWith(ClientConfig{
+			DialFunc:     func(string, string) (net.Conn, error) { return conn, nil },
+			ErrorHandler: func(err error) { errs = append(errs, err) },
+		})
+
+		engine := stats.NewEngine("datadog.test", client)
+		engine.Incr("A")
+		client.Close()
+
+		if len(errs) != 1 || !errors.Is(errs[0], syscall.ECONNREFUSED) || errs[0].Error() != "datadog: writing metrics: connection refused" {
+			t.Error("bad errors:", errs)
+		}
+	})
+
+	t.Run("oversized metrics are reported", func(t *testing.T) {
+		var errs []error
+
+		client := NewClientWith(ClientConfig{
+			BufferSize:   16,
+			DialFunc:     func(string, string) (net.Conn, error) { return &flakyConn{}, nil },
+			ErrorHandler: func(err error) { errs = append(errs, err) },
+		})
+
+		client.HandleMeasures(time.Now(),
+			stats.Measure{Name: "A", Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)}},
+			stats.Measure{Name: strings.Repeat("B", 20), Fields: []stats.Field{stats.MakeField("", 2, stats.Counter)}},
+		)
+		client.Close()
+
+		if len(errs) != 1 || errs[0].Error() != "datadog: metric of length 25 B doesn't fit in the packet size of 16 B" {
+			t.Error("bad errors:", errs)
+		}
+	})
+}
+
+func TestClientSendEvent(t *testing.T) {
+	conn := &flakyConn{}
+
+	client := NewClientWith(ClientConfig{
+		BufferSize: 64,
+		Tags:       []stats.Tag{stats.T("env", "test")},
+		DialFunc:   func(string, string) (net.Conn, error) { return conn, nil },
+	})
+	defer client.Close()
+
+	if err := client.SendEvent(Event{Title: "deploy", Text: "v1\nv2"}); err != nil {
+		t.Error(err)
+	}
+
+	if err := client.SendServiceCheck(ServiceCheck{Name: "api", Status: ServiceCheckWarning}); err != nil {
+		t.Error(err)
+	}
+
+	err := client.SendEvent(Event{Title: "deploy", Text: strings.Repeat("A", 64)})
+	if err == nil || err.Error() != "datadog: event of length 91 B doesn't fit in the packet size of 64 B" {
+		t.Error("bad error for an event exceeding the packet size:", err)
+	}
+
+	const output = "_e{6,6}:deploy|v1\\nv2|#env:test\n_sc|api|1|#env:test\n"
+
+	if b := conn.output.String(); b != output {
+		t.Errorf("bad output:\nwant: %q\ngot:  %q", output, b)
+	}
+}
+
+func TestClientShutdownRetries(t *testing.T) {
+	tests := []struct {
+		scenario string
+		retries  int
+		output   string
+	}{
+		{
+			scenario: "without retries the last metrics are lost",
+			retries:  0,
+			output:   "",
+		},
+		{
+			scenario: "with retries the last metrics are written",
+			retries:  3,
+			output:   "datadog.test.A:1|c\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			conn := &flakyConn{failures: 1, err: syscall.ECONNREFUSED}
+
+			client := NewClientWith(ClientConfig{
+				ShutdownRetries: test.retries,
+				DialFunc:        func(string, string) (net.Conn, error) { return conn, nil },
+			})
+
+			engine := stats.NewEngine("datadog.test", client)
+			engine.Incr("A")
+
+			if err := client.Close(); err != nil {
+				t.Error(err)
+			}
+
+			if b := conn.output.String(); b != test.output {
+				t.Errorf("bad output:\nwant: %q\ngot:  %q", test.output, b)
+			}
+		})
+	}
+}
+
+func TestClientKeepAlive(t *testing.T) {
+	tests := []struct {
+		scenario  string
+		keepAlive time.Duration
+		enabled   bool
+		period    time.Duration
+	}{
+		{
+			scenario: "keep-alives are enabled by default",
+			enabled:  true,
+			period:   DefaultKeepAlive,
+		},
+		{
+			scenario:  "the keep-alive period is configurable",
+			keepAlive: time.Minute,
+			enabled:   true,
+			period:    time.Minute,
+		},
+		{
+			scenario:  "negative periods disable keep-alives",
+			keepAlive: -1,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			conn := &keepAliveRecorder{}
+
+			client := NewClientWith(ClientConfig{
+				KeepAlive: test.keepAlive,
+				DialFunc:  func(string, string) (net.Conn, error) { return conn, nil },
+			})
+
+			if err := client.Close(); err != nil {
+				t.Error(err)
+			}
+
+			if conn.enabled != test.enabled || conn.period != test.period {
+				t.Errorf("bad keep-alive settings: enabled=%t period=%s", conn.enabled, conn.period)
+			}
+		})
+	}
+}
+
+type keepAliveRecorder struct {
+	flakyConn
+	enabled bool
+	period  time.Duration
+}
+
+func (c *keepAliveRecorder) SetKeepAlive(enabled bool) error {
+	c.enabled = enabled
+	return nil
+}
+
+func (c *keepAliveRecorder) SetKeepAlivePeriod(period time.Duration) error {
+	c.period = period
+	return nil
+}
+
+func TestClientCloseTimeout(t *testing.T) {
+	conn := &blockingConn{unblock: make(chan struct{})}
+
+	client := NewClientWith(ClientConfig{
+		ShutdownTimeout: 50 * time.Millisecond,
+		DialFunc:        func(string, string) (net.Conn, error) { return conn, nil },
+	})
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Incr("A")
+
+	start := time.Now()
+	err := client.Close()
+
+	if elapsed := time.Since(start); elapsed > time.Second {
+		t.Errorf("closing the client took too long: %s", elapsed)
+	}
+	if !errors.Is(err, context.DeadlineExceeded) {
+		t.Errorf("bad error: %v", err)
+	}
+
+	select {
+	case <-conn.unblock:
+	default:
+		t.Error("the connection was not closed")
+	}
+}
+
+func TestClientCloseContext(t *testing.T) {
+	conn := &blockingConn{unblock: make(chan struct{})}
+
+	client := NewClientWith(ClientConfig{
+		DialFunc: func(string, string) (net.Conn, error) { return conn, nil },
+	})
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Incr("A")
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	if err := client.CloseContext(ctx); !errors.Is(err, context.Canceled) {
+		t.Errorf("bad error: %v", err)
+	}
+}
+
+// notifyingConn is a flakyConn which closes done when it is closed.
+type notifyingConn struct {
+	flakyConn
+	once sync.Once
+	done chan struct{}
+}
+
+func (c *notifyingConn) Close() error {
+	c.flakyConn.Close()
+	c.once.Do(func() { close(c.done) })
+	return nil
+}
+
+// blockingConn is a connection which blocks all writes until it is closed.
+type blockingConn struct {
+	net.Conn
+	once    sync.Once
+	unblock chan struct{}
+}
+
+func (c *blockingConn) Write(b []byte) (int, error) {
+	<-c.unblock
+	return 0, net.ErrClosed
+}
+
+func (c *blockingConn) Close() error {
+	c.once.Do(func() { close(c.unblock) })
+	return nil
+}
+
+// flakyConn is a net.Conn which fails the first writes with err, then records
+// the data written to it.
+type flakyConn struct {
+	net.Conn
+	failures int // negative to always fail
+	err      error
+	output   bytes.Buffer
+	writes   int
+	closed   bool
+}
+
+func (c *flakyConn) Write(b []byte) (int, error) {
+	if c.failures != 0 {
+		c.failures--
+		return 0, c.err
+	}
+	c.writes++
+	return c.output.Write(b)
+}
+
+func (c *flakyConn) Close() error {
+	c.closed = true
+	return nil
+}
+
+func TestClientUnixgram(t *testing.T) {
+	for _, scheme := range []string{"unixgram://", "unix://"} {
+		t.Run(scheme, func(t *testing.T) {
+			dir, err := ioutil.TempDir("", "datadog")
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer os.RemoveAll(dir)
+
+			path := filepath.Join(dir, "dsd.socket")
+
+			conn, err := net.ListenUnixgram("unixgram", &net.UnixAddr{Name: path, Net: "unixgram"})
+			if err != nil {
+				t.Fatal(err)
+			}
+			defer conn.Close()
+
+			client := NewClientWith(ClientConfig{Address: scheme + path})
+
+			if size := atomic.LoadInt64(&client.bufferSize); size != DefaultUnixBufferSize {
+				t.Errorf("bad buffer size: %d", size)
+			}
+
+			engine := stats.NewEngine("datadog.test", client)
+			engine.Incr("A")
+
+			if err := client.Close(); err != nil {
+				t.Fatal(err)
+			}
+
+			b := make([]byte, 1024)
+			conn.SetReadDeadline(time.Now().Add(time.Second))
+
+			n, err := conn.Read(b)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			if s := string(b[:n]); s != "datadog.test.A:1|c\n" {
+				t.Errorf("bad datagram: %q", s)
+			}
+		})
+	}
+}
+
+func TestClientWriteENOBUFS(t *testing.T) {
+	conn := &flakyConn{failures: 2, err: syscall.ENOBUFS}
+
+	client := NewClientWith(ClientConfig{
+		DialFunc: func(string, string) (net.Conn, error) { return conn, nil },
+	})
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Incr("A")
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if b := conn.output.String(); b != "datadog.test.A:1|c\n" {
+		t.Errorf("the metric was not written after the ENOBUFS errors: %q", b)
+	}
+}
+
+func TestClientChannel(t *testing.T) {
+	channel := make(chan []stats.Measure, 1)
+
+	client := NewClientWith(ClientConfig{Channel: channel})
+	defer client.Close()
+
+	engine := stats.NewEngine("datadog.test", client)
+
+	for i := 0; i != 3; i++ {
+		engine.Incr("A")
+		engine.Set("B", i)
+		engine.Flush()
+
+		select {
+		case measures := <-channel:
+			if len(measures) != 2 {
+				t.Fatal("bad number of measures:", len(measures))
+			}
+			if m := measures[0]; m.Name != "datadog.test.A" || m.Fields[0].Value.Int() != 1 {
+				t.Error("bad counter:", m)
+			}
+			if m := measures[1]; m.Name != "datadog.test.B" || m.Fields[0].Value.Int() != int64(i) {
+				t.Error("bad gauge:", m)
+			}
+		default:
+			t.Fatal("no measures were sent to the channel on flush", i)
+		}
+	}
+
+	// Nobody is receiving, the second flush must drop its measures instead of
+	// blocking.
+	engine.Incr("A")
+	engine.Flush()
+	engine.Incr("A")
+	engine.Flush()
+
+	if n := atomic.LoadUint64(&client.channel.dropped); n != 1 {
+		t.Error("bad number of dropped measures:", n)
+	}
+}
+
+func TestClientPriorityMetrics(t *testing.T) {
+	conn := &flakyConn{}
+	flushes := make(chan string, 10)
+
+	client := NewClientWith(ClientConfig{
+		PriorityMetrics:       []string{"datadog.test.slo.errors"},
+		PriorityFlushInterval: time.Hour,
+		DialFunc:              func(string, string) (net.Conn, error) { return conn, nil },
+		// Called by the goroutine writing to conn, after each batch.
+		AfterFlush: func(io.Writer) { flushes <- conn.output.String() },
+	})
+	defer client.Close()
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Incr("A")
+
+	select {
+	case <-client.priority.signal:
+		t.Error("regular metrics must not trigger a flush")
+	default:
+	}
+
+	engine.Incr("slo.errors")
+
+	// Priority flushes run in the background, the metrics may be written in
+	// more than one batch.
+	for output := ""; strings.Count(output, "\n") != 2; {
+		select {
+		case output = <-flushes:
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for the priority flush:", output)
+		}
+	}
+
+	// The flush reset the time of the last priority flush, which the next
+	// priority metric is checked against before signaling a new one.
+	last := atomic.LoadInt64(&client.priority.last)
+	engine.Incr("slo.errors")
+
+	if atomic.LoadInt64(&client.priority.last) != last {
+		t.Error("priority flushes must honor the minimum interval")
+	}
+}
+
+func TestClientBooleanMetrics(t *testing.T) {
+	metrics := make(chan Metric, 10)
+
+	addr, closer := startTestServer(t, HandlerFunc(func(m Metric, _ net.Addr) {
+		if m.Name != "stats.client.alive" {
+			metrics <- m
+		}
+	}))
+	defer closer.Close()
+
+	client := NewClientWith(ClientConfig{
+		Address:        addr,
+		BooleanMetrics: []string{"datadog.test.up"},
+		SelfMetrics:    true,
+	})
+	defer client.Close()
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Set("up", 2)
+	engine.Flush()
+
+	received := map[string]float64{}
+
+	for len(received) != 2 {
+		select {
+		case m := <-metrics:
+			received[m.Name] = m.Value
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for metrics:", received)
+		}
+	}
+
+	if v := received["datadog.test.up"]; v != 1 {
+		t.Error("the boolean metric was not clamped:", v)
+	}
+
+	if v := received["stats.client.boolean_violations"]; v != 1 {
+		t.Error("bad number of boolean violations:", v)
+	}
+}
+
+func TestClientConfigValidate(t *testing.T) {
+	dialFunc := func(string, string) (net.Conn, error) { return nil, io.ErrClosedPipe }
+
+	tests := []struct {
+		scenario string
+		config   ClientConfig
+		err      string
+	}{
+		{
+			scenario: "channel with a dial function",
+			config:   ClientConfig{Channel: make(chan []stats.Measure), DialFunc: dialFunc},
+			err:      "datadog: DialFunc cannot be set on clients delivering metrics to a Channel since they never dial",
+		},
+		{
+			scenario: "channel with an address",
+			config:   ClientConfig{Channel: make(chan []stats.Measure), Address: DefaultAddress},
+			err:      "datadog: Address cannot be set on clients delivering metrics to a Channel since they never dial",
+		},
+		{
+			scenario: "unsupported network",
+			config:   ClientConfig{Address: "tcp://localhost:8125"},
+			err:      `datadog: unsupported network "tcp" in Address, must be one of udp, udp4, udp6, or unixgram`,
+		},
+		{
+			scenario: "channel with reconnections",
+			config:   ClientConfig{Channel: make(chan []stats.Measure), MaxReconnects: 1},
+			err:      "datadog: MaxReconnects cannot be set on clients delivering metrics to a Channel since they never dial",
+		},
+		{
+			scenario: "channel with self metrics",
+			config:   ClientConfig{Channel: make(chan []stats.Measure), SelfMetrics: true},
+			err:      "datadog: SelfMetrics cannot be enabled on clients delivering metrics to a Channel since they write no datagrams",
+		},
+		{
+			scenario: "negative max reconnects",
+			config:   ClientConfig{MaxReconnects: -1},
+			err:      "datadog: MaxReconnects must not be negative, got -1",
+		},
+		{
+			scenario: "negative buffer size",
+			config:   ClientConfig{BufferSize: -1},
+			err:      "datadog: BufferSize must not be negative, got -1",
+		},
+		{
+			scenario: "negative emit multiplier",
+			config:   ClientConfig{EmitMultiplier: -2},
+			err:      "datadog: EmitMultiplier must not be negative, got -2",
+		},
+		{
+			scenario: "negative significant figures",
+			config:   ClientConfig{SignificantFigures: -3},
+			err:      "datadog: SignificantFigures must not be negative, got -3",
+		},
+		{
+			scenario: "heartbeat without self metrics",
+			config:   ClientConfig{SelfMetricsHeartbeat: "heartbeat"},
+			err:      "datadog: SelfMetricsHeartbeat requires SelfMetrics to be enabled",
+		},
+		{
+			scenario: "negative self metrics sampling",
+			config:   ClientConfig{SelfMetrics: true, SelfMetricsSampling: -1},
+			err:      "datadog: SelfMetricsSampling must not be negative, got -1",
+		},
+		{
+			scenario: "negative shutdown retries",
+			config:   ClientConfig{ShutdownRetries: -1},
+			err:      "datadog: ShutdownRetries must not be negative, got -1",
+		},
+		{
+			scenario: "negative shutdown timeout",
+			config:   ClientConfig{ShutdownRetries: 1, ShutdownTimeout: -time.Second},
+			err:      "datadog: ShutdownTimeout must not be negative, got -1s",
+		},
+		{
+			scenario: "negative priority flush interval",
+			config:   ClientConfig{PriorityMetrics: []string{"A"}, PriorityFlushInterval: -time.Second},
+			err:      "datadog: PriorityFlushInterval must not be negative, got -1s",
+		},
+		{
+			scenario: "separator used by the protocol",
+			config:   ClientConfig{Separator: '|'},
+			err:      `datadog: Separator '|' may appear in metrics, it must be an ASCII control character like '\n'`,
+		},
+		{
+			scenario: "separator used in metric names",
+			config:   ClientConfig{Separator: 'a'},
+			err:      `datadog: Separator 'a' may appear in metrics, it must be an ASCII control character like '\n'`,
+		},
+		{
+			scenario: "unknown max value policy",
+			config:   ClientConfig{MaxValuePolicy: 42},
+			err:      "datadog: unknown MaxValuePolicy 42",
+		},
+		{
+			scenario: "unknown tag format",
+			config:   ClientConfig{TagFormat: 42},
+			err:      "datadog: unknown TagFormat 42",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			if err := test.config.Validate(); err == nil || err.Error() != test.err {
+				t.Error("bad validation error:", err)
+			}
+
+			if err := NewClientWith(test.config).Close(); err == nil || err.Error() != test.err {
+				t.Error("bad error returned when closing the client:", err)
+			}
+		})
+	}
+
+	if err := (ClientConfig{Channel: make(chan []stats.Measure)}).Validate(); err != nil {
+		t.Error("unexpected error on a valid configuration:", err)
+	}
+}
+
 func BenchmarkClient(b *testing.B) {
 	log.SetOutput(ioutil.Discard)
 
@@ -0,0 +1,133 @@
+package datadog
+
+import (
+	"html/template"
+	"net/http"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/objconv/json"
+)
+
+// ClientStats is a snapshot of the internal state of a client, it is returned
+// by the client's Stats method.
+type ClientStats struct {
+	// Number of metrics written by the client.
+	Metrics uint64 `json:"metrics"`
+
+	// Number of datagrams and bytes successfully written by the client.
+	Datagrams uint64 `json:"datagrams"`
+	Bytes     uint64 `json:"bytes"`
+
+	// Number of metrics dropped because they didn't fit in a datagram.
+	OversizedMetrics uint64 `json:"oversized_metrics"`
+
+	// Number of datagrams that could not be written.
+	WriteErrors uint64 `json:"write_errors"`
+
+	// Counters of the corrections applied to metrics, see SelfMetricsPrefix
+	// for details.
+	BooleanViolations uint64 `json:"boolean_violations"`
+	ValuesClamped     uint64 `json:"values_clamped"`
+	ValuesDropped     uint64 `json:"values_dropped"`
+
+	// Number of measures dropped because the Channel was full.
+	ChannelDropped uint64 `json:"channel_dropped"`
+
+	// Maximum size of the datagrams sent by the client.
+	PacketSize int `json:"packet_size"`
+
+	// Time spent in the last call to Flush.
+	FlushDuration time.Duration `json:"flush_duration"`
+
+	// Time at which the CanaryMetric was last sent, zero if the client is not
+	// configured with one or never flushed.
+	CanaryTime time.Time `json:"canary_time"`
+
+	// Error that occurred when the client was created, empty if none.
+	Error string `json:"error,omitempty"`
+}
+
+// Stats returns a snapshot of the internal state of the client.
+func (c *Client) Stats() ClientStats {
+	n := c.counters.load()
+
+	s := ClientStats{
+		Metrics:           n.metrics,
+		Datagrams:         n.datagrams,
+		Bytes:             n.bytes,
+		OversizedMetrics:  n.oversizedMetrics,
+		WriteErrors:       n.writeErrors,
+		FlushDuration:     time.Duration(n.flushDuration),
+		BooleanViolations: n.booleanViolations,
+		ValuesClamped:     n.clampedValues,
+		ValuesDropped:     n.droppedValues,
+		PacketSize:        int(atomic.LoadInt64(&c.bufferSize)),
+	}
+
+	if c.channel != nil {
+		s.ChannelDropped = atomic.LoadUint64(&c.channel.dropped)
+	}
+
+	if c.canary != nil {
+		s.CanaryTime = c.canary.lastEmitted()
+	}
+
+	if err := c.dialError(); err != nil {
+		s.Error = err.Error()
+	}
+
+	return s
+}
+
+// Inspect returns the snapshot of the internal state of the client returned by
+// Stats, satisfies the stats.Inspector interface.
+func (c *Client) Inspect() interface{} {
+	return c.Stats()
+}
+
+// DebugHandler returns a http.Handler which renders the state of c as a human
+// readable page, or as JSON when the request has a format=json query parameter.
+//
+// Similarly to net/http/pprof, programs would typically register the handler
+// on their debug server, under the path of stats.DebugHandler which renders
+// the state of the engine and its handlers, including c:
+//
+//	http.Handle("/debug/stats", stats.DebugHandler(stats.DefaultEngine))
+//	http.Handle("/debug/stats/datadog", datadog.DebugHandler(client))
+func DebugHandler(c *Client) http.Handler {
+	return http.HandlerFunc(func(res http.ResponseWriter, req *http.Request) {
+		s := c.Stats()
+
+		if req.URL.Query().Get("format") == "json" {
+			res.Header().Set("Content-Type", "application/json; charset=utf-8")
+			json.NewPrettyEncoder(res).Encode(s)
+			return
+		}
+
+		res.Header().Set("Content-Type", "text/html; charset=utf-8")
+		debugTemplate.Execute(res, s)
+	})
+}
+
+var debugTemplate = template.Must(template.New("stats").Parse(`<html>
+<head><title>/debug/stats/datadog</title></head>
+<body>
+<table>
+<tr><td>metrics</td><td>{{.Metrics}}</td></tr>
+<tr><td>datagrams</td><td>{{.Datagrams}}</td></tr>
+<tr><td>bytes</td><td>{{.Bytes}}</td></tr>
+<tr><td>oversized metrics</td><td>{{.OversizedMetrics}}</td></tr>
+<tr><td>write errors</td><td>{{.WriteErrors}}</td></tr>
+<tr><td>boolean violations</td><td>{{.BooleanViolations}}</td></tr>
+<tr><td>values clamped</td><td>{{.ValuesClamped}}</td></tr>
+<tr><td>values dropped</td><td>{{.ValuesDropped}}</td></tr>
+<tr><td>channel dropped</td><td>{{.ChannelDropped}}</td></tr>
+<tr><td>packet size</td><td>{{.PacketSize}}</td></tr>
+<tr><td>flush duration</td><td>{{.FlushDuration}}</td></tr>
+{{if not .CanaryTime.IsZero}}<tr><td>canary time</td><td>{{.CanaryTime}}</td></tr>
+{{end}}{{if .Error}}<tr><td>error</td><td>{{.Error}}</td></tr>
+{{end}}</table>
+</body>
+</html>
+`))
@@ -0,0 +1,162 @@
+package datadog
+
+import (
+	"errors"
+	"io/ioutil"
+	"net"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/segmentio/objconv/json"
+	"github.com/segmentio/stats"
+)
+
+func TestDebugHandler(t *testing.T) {
+	client := NewClientWith(ClientConfig{
+		BooleanMetrics: []string{"datadog.test.up"},
+		DialFunc: func(string, string) (net.Conn, error) {
+			return &flakyConn{}, nil
+		},
+	})
+	defer client.Close()
+
+	engine := stats.NewEngine("datadog.test", client)
+	engine.Incr("A")
+	engine.Set("up", 2)
+	client.Flush()
+
+	server := httptest.NewServer(DebugHandler(client))
+	defer server.Close()
+
+	t.Run("html", func(t *testing.T) {
+		res, err := server.Client().Get(server.URL + "/debug/stats/datadog")
+		if err != nil {
+			t.Fatal(err)
+		}
+		b, _ := ioutil.ReadAll(res.Body)
+		res.Body.Close()
+
+		if contentType := res.Header.Get("Content-Type"); contentType != "text/html; charset=utf-8" {
+			t.Error("bad content type:", contentType)
+		}
+
+		for _, row := range []string{
+			"<tr><td>metrics</td><td>2</td></tr>",
+			"<tr><td>boolean violations</td><td>1</td></tr>",
+		} {
+			if !strings.Contains(string(b), row) {
+				t.Errorf("row %q not found in %q", row, b)
+			}
+		}
+	})
+
+	t.Run("json", func(t *testing.T) {
+		res, err := server.Client().Get(server.URL + "/debug/stats/datadog?format=json")
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer res.Body.Close()
+
+		if contentType := res.Header.Get("Content-Type"); contentType != "application/json; charset=utf-8" {
+			t.Error("bad content type:", contentType)
+		}
+
+		var s ClientStats
+		if err := json.NewDecoder(res.Body).Decode(&s); err != nil {
+			t.Fatal(err)
+		}
+
+		if s.Metrics != 2 || s.BooleanViolations != 1 || s.PacketSize != DefaultBufferSize || len(s.Error) != 0 {
+			t.Errorf("bad stats: %+v", s)
+		}
+	})
+}
+
+func TestClientStatsDatagrams(t *testing.T) {
+	conn := &flakyConn{}
+
+	client := NewClientWith(ClientConfig{
+		BufferSize:   32,
+		DialFunc:     func(string, string) (net.Conn, error) { return conn, nil },
+		ErrorHandler: func(error) {},
+	})
+	defer client.Close()
+
+	client.HandleMeasures(time.Now(),
+		stats.Measure{Name: "A", Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)}},
+		stats.Measure{Name: strings.Repeat("B", 40), Fields: []stats.Field{stats.MakeField("", 2, stats.Counter)}},
+		stats.Measure{Name: "C", Fields: []stats.Field{stats.MakeField("", 3, stats.Counter)}},
+	)
+	client.Flush()
+
+	s := client.Stats()
+
+	if s.OversizedMetrics != 1 {
+		t.Error("bad number of oversized metrics:", s.OversizedMetrics)
+	}
+
+	if s.Datagrams != uint64(conn.writes) || s.Bytes != uint64(conn.output.Len()) || conn.output.String() != "A:1|c\nC:3|c\n" {
+		t.Errorf("bad datagram counters: %d datagrams, %d bytes, output: %q", s.Datagrams, s.Bytes, conn.output.String())
+	}
+}
+
+func TestClientStatsWriteErrors(t *testing.T) {
+	conn := &flakyConn{failures: -1, err: errors.New("connection refused")}
+
+	client := NewClientWith(ClientConfig{
+		DialFunc:     func(string, string) (net.Conn, error) { return conn, nil },
+		ErrorHandler: func(error) {},
+	})
+	defer client.Close()
+
+	client.HandleMeasures(time.Now(), stats.Measure{
+		Name:   "A",
+		Fields: []stats.Field{stats.MakeField("", 1, stats.Counter)},
+	})
+	client.Flush()
+
+	s := client.Stats()
+
+	if s.WriteErrors != 1 || s.Datagrams != 0 {
+		t.Errorf("bad write counters: %d errors, %d datagrams", s.WriteErrors, s.Datagrams)
+	}
+
+	if s.FlushDuration <= 0 {
+		t.Error("bad flush duration:", s.FlushDuration)
+	}
+}
+
+func TestClientInspect(t *testing.T) {
+	client := NewClientWith(ClientConfig{
+		DialFunc: func(string, string) (net.Conn, error) {
+			return &flakyConn{}, nil
+		},
+	})
+	defer client.Close()
+
+	engine := stats.NewEngine("datadog.test", client)
+	handler := stats.DebugHandler(engine)
+
+	engine.Incr("A")
+	client.Flush()
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/debug/stats", nil))
+
+	var s struct {
+		Handlers []struct {
+			Type  string      `json:"type"`
+			State ClientStats `json:"state"`
+		} `json:"handlers"`
+	}
+
+	if err := json.NewDecoder(res.Body).Decode(&s); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Handlers) != 1 || s.Handlers[0].Type != "*datadog.Client" || s.Handlers[0].State.Metrics != 1 {
+		t.Errorf("bad handlers: %+v", s.Handlers)
+	}
+}
@@ -2,7 +2,9 @@ package datadog
 
 import (
 	"math"
+	"math/rand"
 	"strconv"
+	"sync/atomic"
 
 	"github.com/segmentio/stats"
 )
@@ -17,63 +19,370 @@ func AppendMeasure(b []byte, m stats.Measure) []byte {
 // representation of a measure to a memory buffer. Tags listed in the filters map
 // are removed. (some tags may not be suitable for submission to DataDog)
 func AppendMeasureFiltered(b []byte, m stats.Measure, filters map[string]struct{}) []byte {
+	return format{filters: filters}.appendMeasure(b, m)
+}
+
+// format carries the options applied when serializing measures to the
+// dogstatsd protocol.
+type format struct {
+	// Prefix of all metric names.
+	namespace string
+
+	// Tags that are removed from the measures.
+	filters map[string]struct{}
+
+	// Number of significant figures that float values are rounded to, zero
+	// means full precision.
+	significantFigures int
+
+	// Names of metrics that must only take the values 0 or 1.
+	booleans map[string]struct{}
+
+	// Maximum absolute value of metrics and the policy applied to values
+	// exceeding it, zero means no limit.
+	maxValue       float64
+	maxValuePolicy ValuePolicy
+
+	// Counters updated when the format has to correct a metric, may be nil if
+	// no corrections are configured.
+	counters *counters
+
+	// When true, histograms are sent as distributions.
+	distributions bool
+
+	// Number of copies of each metric, see ClientConfig.EmitMultiplier.
+	emitMultiplier float64
+
+	// Function applied to measures before they are serialized, see
+	// ClientConfig.MeasureFilter.
+	measureFilter func(stats.Measure) (stats.Measure, bool)
+
+	// Tags added to all metrics, unless they already have a tag of the same
+	// name.
+	tags []stats.Tag
+
+	// Tags added to metrics depending on their type.
+	typeTags map[stats.FieldType][]stats.Tag
+
+	// When true, the number of metrics written is added to the counters.
+	countMetrics bool
+
+	// Byte written after each metric, zero means DefaultSeparator.
+	separator byte
+
+	// Encoding of the metric tags, see ClientConfig.TagFormat.
+	tagFormat TagFormat
+}
+
+func (f format) lineSeparator() byte {
+	if f.separator == 0 {
+		return DefaultSeparator
+	}
+	return f.separator
+}
+
+func (f format) appendMeasure(b []byte, m stats.Measure) []byte {
+	filters := f.filters
+	count := uint64(0)
+
 	for _, field := range m.Fields {
+		if f.maxValue != 0 {
+			var ok bool
+			if field, ok = f.limit(field); !ok {
+				continue
+			}
+		}
+
+		b = append(b, f.namespace...)
 		b = append(b, m.Name...)
 		if len(field.Name) != 0 {
 			b = append(b, '.')
 			b = append(b, field.Name...)
 		}
-		b = append(b, ':')
-
-		switch v := field.Value; v.Type() {
-		case stats.Bool:
-			if v.Bool() {
-				b = append(b, '1')
-			} else {
-				b = append(b, '0')
-			}
-		case stats.Int:
-			b = strconv.AppendInt(b, v.Int(), 10)
-		case stats.Uint:
-			b = strconv.AppendUint(b, v.Uint(), 10)
-		case stats.Float:
-			b = strconv.AppendFloat(b, normalizeFloat(v.Float()), 'g', -1, 64)
-		case stats.Duration:
-			b = strconv.AppendFloat(b, v.Duration().Seconds(), 'g', -1, 64)
-		default:
-			b = append(b, '0')
+		if f.tagFormat == InfluxDBTags || f.tagFormat == LibratoTags {
+			b = f.appendInlineTags(b, m, field)
 		}
+		b = append(b, ':')
+		b = f.appendValue(b, m, field)
 
 		switch field.Type() {
 		case stats.Counter:
 			b = append(b, '|', 'c')
 		case stats.Gauge:
 			b = append(b, '|', 'g')
+		case stats.Distribution:
+			b = append(b, '|', 'd')
+		case stats.SetType:
+			b = append(b, '|', 's')
 		default:
-			b = append(b, '|', 'h')
+			if f.distributions {
+				b = append(b, '|', 'd')
+			} else {
+				b = append(b, '|', 'h')
+			}
+		}
+
+		if rate := m.SampleRate; rate > 0 && rate < 1 {
+			b = append(b, '|', '@')
+			b = strconv.AppendFloat(b, rate, 'g', -1, 64)
+		}
+
+		if f.tagFormat != DatadogTags {
+			b = append(b, f.lineSeparator())
+			count++
+			continue
 		}
 
-		if n := len(m.Tags); n != 0 {
-			b = append(b, '|', '#')
+		// The |# prefix is only written with the first tag, measures may have
+		// no tags left once filtered.
+		tagged := false
+
+		for _, t := range m.Tags {
+			if _, ok := filters[t.Name]; !ok {
+				b, tagged = appendTag(b, tagged, t), true
+			}
+		}
 
-			for i, t := range m.Tags {
-				if _, ok := filters[t.Name]; !ok {
-					if i != 0 {
-						b = append(b, ',')
-					}
-					b = append(b, t.Name...)
-					b = append(b, ':')
-					b = append(b, t.Value...)
-				}
+		for _, t := range f.tags {
+			if !hasTag(m.Tags, t.Name) {
+				b, tagged = appendTag(b, tagged, t), true
 			}
 		}
 
-		b = append(b, '\n')
+		for _, t := range f.typeTags[field.Type()] {
+			b, tagged = appendTag(b, tagged, t), true
+		}
+
+		b = append(b, f.lineSeparator())
+		count++
+	}
+
+	if f.countMetrics {
+		atomic.AddUint64(&f.counters.metrics, count)
+	}
+
+	return b
+}
+
+// appendInlineTags appends the tags of a metric right after its name, in the
+// InfluxDB (name,k=v) or Librato (name#k=v) statsd extensions.
+func (f format) appendInlineTags(b []byte, m stats.Measure, field stats.Field) []byte {
+	n := 0
+
+	for _, t := range m.Tags {
+		if _, ok := f.filters[t.Name]; !ok {
+			b, n = f.appendInlineTag(b, n, t), n+1
+		}
+	}
+
+	for _, t := range f.tags {
+		if !hasTag(m.Tags, t.Name) {
+			b, n = f.appendInlineTag(b, n, t), n+1
+		}
+	}
+
+	for _, t := range f.typeTags[field.Type()] {
+		b, n = f.appendInlineTag(b, n, t), n+1
+	}
+
+	return b
+}
+
+// appendInlineTag appends t to the inline tags of a metric, n is the number of
+// tags already written.
+func (f format) appendInlineTag(b []byte, n int, t stats.Tag) []byte {
+	switch {
+	case n != 0:
+		b = append(b, ',')
+	case f.tagFormat == LibratoTags:
+		b = append(b, '#')
+	default:
+		b = append(b, ',')
+	}
+	b = append(b, t.Name...)
+	b = append(b, '=')
+	return append(b, t.Value...)
+}
+
+// appendTag appends t to the tags of a metric, tagged tells whether the metric
+// already had tags.
+func appendTag(b []byte, tagged bool, t stats.Tag) []byte {
+	if tagged {
+		b = append(b, ',')
+	} else {
+		b = append(b, '|', '#')
+	}
+	b = append(b, t.Name...)
+	b = append(b, ':')
+	return append(b, t.Value...)
+}
+
+// mergeTags returns tags with the global tags of f that it doesn't already
+// have, tags is not modified.
+func (f format) mergeTags(tags []stats.Tag) []stats.Tag {
+	for _, t := range f.tags {
+		if !hasTag(tags, t.Name) {
+			tags = append(tags[:len(tags):len(tags)], t)
+		}
+	}
+	return tags
+}
+
+func hasTag(tags []stats.Tag, name string) bool {
+	for _, t := range tags {
+		if t.Name == name {
+			return true
+		}
+	}
+	return false
+}
+
+func (f format) appendMeasureMultiplied(b []byte, m stats.Measure) []byte {
+	copies := int(f.emitMultiplier)
+
+	if rand.Float64() < f.emitMultiplier-float64(copies) {
+		copies++
+	}
+
+	if copies <= 1 {
+		if copies == 1 {
+			b = f.appendMeasure(b, m)
+		}
+		return b
+	}
+
+	tags := make([]stats.Tag, len(m.Tags)+1)
+	copy(tags, m.Tags)
+	m.Tags = tags
+
+	for i := 0; i != copies; i++ {
+		tags[len(tags)-1] = stats.T(EmitCopyTag, strconv.Itoa(i))
+		b = f.appendMeasure(b, m)
 	}
 
 	return b
 }
 
+func (f format) appendValue(b []byte, m stats.Measure, field stats.Field) []byte {
+	v := field.Value
+
+	if f.booleans != nil {
+		if _, ok := f.booleans[metricName(m, field)]; ok {
+			return append(b, f.boolean(v))
+		}
+	}
+
+	switch v.Type() {
+	case stats.Bool:
+		if v.Bool() {
+			b = append(b, '1')
+		} else {
+			b = append(b, '0')
+		}
+	case stats.Int:
+		b = strconv.AppendInt(b, v.Int(), 10)
+	case stats.Uint:
+		b = strconv.AppendUint(b, v.Uint(), 10)
+	case stats.Float:
+		b = strconv.AppendFloat(b, f.round(normalizeFloat(v.Float())), 'g', -1, 64)
+	case stats.Duration:
+		b = strconv.AppendFloat(b, f.round(v.Duration().Seconds()), 'g', -1, 64)
+	default:
+		b = append(b, '0')
+	}
+
+	return b
+}
+
+// limit applies the maximum value policy to field, it returns false if the
+// field must be dropped.
+func (f format) limit(field stats.Field) (stats.Field, bool) {
+	x := floatValue(field.Value)
+
+	if math.Abs(x) <= f.maxValue {
+		return field, true
+	}
+
+	if f.maxValuePolicy == DropValues {
+		if f.counters != nil {
+			atomic.AddUint64(&f.counters.droppedValues, 1)
+		}
+		return field, false
+	}
+
+	if f.counters != nil {
+		atomic.AddUint64(&f.counters.clampedValues, 1)
+	}
+	return stats.MakeField(field.Name, math.Copysign(f.maxValue, x), field.Type()), true
+}
+
+// boolean returns the representation of v as a boolean metric value, values
+// other than 0 or 1 are clamped and counted as violations.
+func (f format) boolean(v stats.Value) byte {
+	x := floatValue(v)
+
+	switch x {
+	case 0:
+		return '0'
+	case 1:
+		return '1'
+	}
+
+	if f.counters != nil {
+		atomic.AddUint64(&f.counters.booleanViolations, 1)
+	}
+
+	if x > 0 {
+		return '1'
+	}
+	return '0'
+}
+
+func (f format) round(v float64) float64 {
+	if f.significantFigures <= 0 || v == 0 || math.IsInf(v, 0) || math.IsNaN(v) {
+		return v
+	}
+
+	// Dividing or multiplying by an exact power of ten (instead of multiplying
+	// by its inverse) avoids introducing rounding errors which would make the
+	// serialized value longer than necessary (1230 vs 1229.9999999999998).
+	e := f.significantFigures - 1 - int(math.Floor(math.Log10(math.Abs(v))))
+
+	// The powers of ten above 1e308 overflow to infinity, which happens with
+	// subnormal values, and would turn them into NaN.
+	if e > 308 {
+		return v
+	}
+
+	if e >= 0 {
+		p := math.Pow10(e)
+		return math.Round(v*p) / p
+	}
+
+	p := math.Pow10(-e)
+	return math.Round(v/p) * p
+}
+
+// floatValue returns v as a float, durations are converted to seconds like
+// when they are serialized.
+func floatValue(v stats.Value) float64 {
+	switch v.Type() {
+	case stats.Bool:
+		if v.Bool() {
+			return 1
+		}
+	case stats.Int:
+		return float64(v.Int())
+	case stats.Uint:
+		return float64(v.Uint())
+	case stats.Float:
+		return v.Float()
+	case stats.Duration:
+		return v.Duration().Seconds()
+	}
+	return 0
+}
+
 func normalizeFloat(f float64) float64 {
 	switch {
 	case math.IsNaN(f):
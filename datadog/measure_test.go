@@ -1,6 +1,7 @@
 package datadog
 
 import (
+	"math"
 	"testing"
 	"time"
 
@@ -53,3 +54,390 @@ func TestAppendMeasure(t *testing.T) {
 		})
 	}
 }
+
+func TestAppendMeasureSignificantFigures(t *testing.T) {
+	tests := []struct {
+		value interface{}
+		s     string
+	}{
+		{value: 1234.5678, s: "value:1230|g\n"},
+		{value: 0.0012345, s: "value:0.00123|g\n"},
+		{value: -98765.4321, s: "value:-98800|g\n"},
+		{value: 1234567 * time.Microsecond, s: "value:1.23|g\n"},
+		{value: 1234, s: "value:1234|g\n"},     // integers are left untouched
+		{value: 1e-310, s: "value:1e-310|g\n"}, // subnormal values are left untouched
+	}
+
+	f := format{significantFigures: 3}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			m := stats.Measure{
+				Fields: []stats.Field{stats.MakeField("", test.value, stats.Gauge)},
+				Name:   "value",
+			}
+			if s := string(f.appendMeasure(nil, m)); s != test.s {
+				t.Error("bad metric representation:")
+				t.Log("expected:", test.s)
+				t.Log("found:   ", s)
+			}
+		})
+	}
+}
+
+func TestAppendMeasureMaxValue(t *testing.T) {
+	m := stats.Measure{
+		Name: "value",
+		Fields: []stats.Field{
+			stats.MakeField("a", 1e300, stats.Gauge),
+			stats.MakeField("b", -1e300, stats.Histogram),
+			stats.MakeField("c", 42, stats.Counter),
+		},
+	}
+
+	tests := []struct {
+		policy  ValuePolicy
+		s       string
+		clamped uint64
+		dropped uint64
+	}{
+		{
+			policy:  ClampValues,
+			s:       "value.a:1e+06|g\nvalue.b:-1e+06|h\nvalue.c:42|c\n",
+			clamped: 2,
+		},
+		{
+			policy:  DropValues,
+			s:       "value.c:42|c\n",
+			dropped: 2,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			c := &counters{}
+			f := format{maxValue: 1e6, maxValuePolicy: test.policy, counters: c}
+
+			if s := string(f.appendMeasure(nil, m)); s != test.s {
+				t.Error("bad metric representation:")
+				t.Log("expected:", test.s)
+				t.Log("found:   ", s)
+			}
+
+			if c.clampedValues != test.clamped {
+				t.Error("bad number of clamped values:", c.clampedValues)
+			}
+
+			if c.droppedValues != test.dropped {
+				t.Error("bad number of dropped values:", c.droppedValues)
+			}
+		})
+	}
+}
+
+func TestAppendMeasureTypeTags(t *testing.T) {
+	f := format{
+		filters: map[string]struct{}{"http_req_path": {}},
+		typeTags: map[stats.FieldType][]stats.Tag{
+			stats.Histogram: {stats.T("unit", "ms")},
+			stats.Gauge:     {stats.T("kind", "gauge")},
+		},
+	}
+
+	tests := []struct {
+		m stats.Measure
+		s string
+	}{
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					stats.MakeField("count", 1, stats.Counter),
+					stats.MakeField("rtt", 42, stats.Histogram),
+					stats.MakeField("inflight", 3, stats.Gauge),
+				},
+				Tags: []stats.Tag{stats.T("service", "api")},
+			},
+			s: "request.count:1|c|#service:api\n" +
+				"request.rtt:42|h|#service:api,unit:ms\n" +
+				"request.inflight:3|g|#service:api,kind:gauge\n",
+		},
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					stats.MakeField("count", 1, stats.Counter),
+					stats.MakeField("rtt", 42, stats.Histogram),
+				},
+			},
+			s: "request.count:1|c\n" +
+				"request.rtt:42|h|#unit:ms\n",
+		},
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					stats.MakeField("count", 1, stats.Counter),
+					stats.MakeField("rtt", 42, stats.Histogram),
+				},
+				Tags: []stats.Tag{stats.T("http_req_path", "/")},
+			},
+			s: "request.count:1|c\n" +
+				"request.rtt:42|h|#unit:ms\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			if s := string(f.appendMeasure(nil, test.m)); s != test.s {
+				t.Error("bad metric representation:")
+				t.Log("expected:", test.s)
+				t.Log("found:   ", s)
+			}
+		})
+	}
+}
+
+func TestAppendMeasureTags(t *testing.T) {
+	m := stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+			stats.MakeField("rtt", 42, stats.Histogram),
+		},
+		Tags: []stats.Tag{stats.T("service", "api")},
+	}
+
+	tests := []struct {
+		scenario string
+		format   format
+		s        string
+	}{
+		{
+			scenario: "without global tags the output is unchanged",
+			format:   format{},
+			s:        "request.count:1|c|#service:api\nrequest.rtt:42|h|#service:api\n",
+		},
+		{
+			scenario: "global tags are added to all metrics",
+			format:   format{tags: []stats.Tag{stats.T("env", "prod"), stats.T("host", "localhost")}},
+			s: "request.count:1|c|#service:api,env:prod,host:localhost\n" +
+				"request.rtt:42|h|#service:api,env:prod,host:localhost\n",
+		},
+		{
+			scenario: "tags of the measures take precedence",
+			format:   format{tags: []stats.Tag{stats.T("env", "prod"), stats.T("service", "other")}},
+			s:        "request.count:1|c|#service:api,env:prod\nrequest.rtt:42|h|#service:api,env:prod\n",
+		},
+		{
+			scenario: "global tags compose with type tags",
+			format: format{
+				tags:     []stats.Tag{stats.T("env", "prod")},
+				typeTags: map[stats.FieldType][]stats.Tag{stats.Histogram: {stats.T("unit", "ms")}},
+			},
+			s: "request.count:1|c|#service:api,env:prod\n" +
+				"request.rtt:42|h|#service:api,env:prod,unit:ms\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			if s := string(test.format.appendMeasure(nil, m)); s != test.s {
+				t.Error("bad metric representation:")
+				t.Log("expected:", test.s)
+				t.Log("found:   ", s)
+			}
+		})
+	}
+
+	// Measures without tags start the list of tags with the global ones.
+	if s := string(format{tags: []stats.Tag{stats.T("env", "prod")}}.appendMeasure(nil, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+	})); s != "request.count:1|c|#env:prod\n" {
+		t.Errorf("bad metric representation: %q", s)
+	}
+
+	// Same when all the tags of the measure are filtered.
+	if s := string(format{
+		filters: map[string]struct{}{"http_req_path": {}},
+		tags:    []stats.Tag{stats.T("env", "prod")},
+	}.appendMeasure(nil, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		Tags:   []stats.Tag{stats.T("http_req_path", "/")},
+	})); s != "request.count:1|c|#env:prod\n" {
+		t.Errorf("bad metric representation with filtered tags: %q", s)
+	}
+
+	// Filtered tags leave no separator behind.
+	if s := string(format{
+		filters: map[string]struct{}{"http_req_path": {}},
+	}.appendMeasure(nil, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		Tags:   []stats.Tag{stats.T("http_req_path", "/"), stats.T("service", "api")},
+	})); s != "request.count:1|c|#service:api\n" {
+		t.Errorf("bad metric representation with a filtered first tag: %q", s)
+	}
+
+	if s := string(format{
+		filters: map[string]struct{}{"http_req_path": {}},
+	}.appendMeasure(nil, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		Tags:   []stats.Tag{stats.T("http_req_path", "/")},
+	})); s != "request.count:1|c\n" {
+		t.Errorf("bad metric representation with only filtered tags: %q", s)
+	}
+}
+
+func TestAppendMeasureDistributions(t *testing.T) {
+	m := stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+			stats.MakeField("rtt", 42*time.Millisecond, stats.Histogram),
+		},
+		Tags: []stats.Tag{stats.T("service", "api")},
+	}
+
+	const expect = "request.count:1|c|#service:api\nrequest.rtt:0.042|d|#service:api\n"
+
+	if s := string(format{distributions: true}.appendMeasure(nil, m)); s != expect {
+		t.Error("bad metric representation:")
+		t.Log("expected:", expect)
+		t.Log("found:   ", s)
+	}
+}
+
+func TestAppendMeasureFieldTypes(t *testing.T) {
+	m := stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("rtt", 42*time.Millisecond, stats.Histogram),
+			stats.MakeField("size", 1024, stats.Distribution),
+			stats.MakeField("user", 7, stats.SetType),
+			stats.MakeField("session", int64(math.MaxInt64), stats.SetType),
+		},
+	}
+
+	const expect = "request.rtt:0.042|h\nrequest.size:1024|d\nrequest.user:7|s\n" +
+		"request.session:9223372036854775807|s\n"
+
+	if s := string(format{}.appendMeasure(nil, m)); s != expect {
+		t.Error("bad metric representation:")
+		t.Log("expected:", expect)
+		t.Log("found:   ", s)
+	}
+}
+
+func TestAppendMeasureSampleRate(t *testing.T) {
+	tests := []struct {
+		rate float64
+		s    string
+	}{
+		{rate: 0, s: "cache.hits:1|c|#a:1\n"},
+		{rate: 0.25, s: "cache.hits:1|c|@0.25|#a:1\n"},
+		{rate: 1, s: "cache.hits:1|c|#a:1\n"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			m := stats.Measure{
+				Name:       "cache",
+				Fields:     []stats.Field{stats.MakeField("hits", 1, stats.Counter)},
+				Tags:       []stats.Tag{stats.T("a", "1")},
+				SampleRate: test.rate,
+			}
+
+			if s := string(format{}.appendMeasure(nil, m)); s != test.s {
+				t.Errorf("bad metric representation: %q", s)
+			}
+		})
+	}
+}
+
+func TestAppendMeasureTagFormat(t *testing.T) {
+	m := stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+		},
+		Tags: []stats.Tag{stats.T("method", "GET"), stats.T("http_req_path", "/")},
+	}
+
+	tests := []struct {
+		tagFormat TagFormat
+		s         string
+	}{
+		{
+			tagFormat: DatadogTags,
+			s:         "request.count:1|c|#method:GET,env:test\n",
+		},
+		{
+			tagFormat: NoTags,
+			s:         "request.count:1|c\n",
+		},
+		{
+			tagFormat: InfluxDBTags,
+			s:         "request.count,method=GET,env=test:1|c\n",
+		},
+		{
+			tagFormat: LibratoTags,
+			s:         "request.count#method=GET,env=test:1|c\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.s, func(t *testing.T) {
+			f := format{
+				tagFormat: test.tagFormat,
+				filters:   map[string]struct{}{"http_req_path": {}},
+				tags:      []stats.Tag{stats.T("env", "test")},
+			}
+
+			if s := string(f.appendMeasure(nil, m)); s != test.s {
+				t.Error("bad metric representation:")
+				t.Log("expected:", test.s)
+				t.Log("found:   ", s)
+			}
+		})
+	}
+}
+
+func TestAppendMeasureNamespace(t *testing.T) {
+	m := stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+			stats.MakeField("inflight", 2, stats.Gauge),
+			stats.MakeField("rtt", 42, stats.Histogram),
+		},
+		Tags: []stats.Tag{stats.T("service", "api")},
+	}
+
+	tests := []struct {
+		namespace string
+		s         string
+	}{
+		{
+			namespace: "",
+			s:         "request.count:1|c|#service:api\nrequest.inflight:2|g|#service:api\nrequest.rtt:42|h|#service:api\n",
+		},
+		{
+			namespace: "myservice.",
+			s:         "myservice.request.count:1|c|#service:api\nmyservice.request.inflight:2|g|#service:api\nmyservice.request.rtt:42|h|#service:api\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.namespace, func(t *testing.T) {
+			if s := string(format{namespace: test.namespace}.appendMeasure(nil, m)); s != test.s {
+				t.Error("bad metric representation:")
+				t.Log("expected:", test.s)
+				t.Log("found:   ", s)
+			}
+		})
+	}
+}
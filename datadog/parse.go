@@ -56,7 +56,7 @@ func parseEvent(s string) (e Event, err error) {
 	e = Event{
 		Priority:  EventPriorityNormal,
 		AlertType: EventAlertTypeInfo,
-		Title:     rawTitle,
+		Title:     strings.Replace(rawTitle, "\\n", "\n", -1),
 		Text:      strings.Replace(rawText, "\\n", "\n", -1),
 	}
 
@@ -0,0 +1,111 @@
+package datadog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// priorityFlush implements the logic of triggering early flushes when priority
+// metrics are produced.
+//
+// The flushes are run by a background goroutine, the calls producing priority
+// metrics only signal it so they never block on the connection.
+type priorityFlush struct {
+	last     int64 // unix time of the last flush in nanoseconds, accessed atomically
+	interval int64
+	names    map[string]struct{}
+
+	signal chan struct{}
+	once   sync.Once
+	done   chan struct{}
+	join   chan struct{}
+}
+
+func newPriorityFlush(names []string, interval time.Duration) *priorityFlush {
+	p := &priorityFlush{
+		interval: int64(interval),
+		names:    make(map[string]struct{}, len(names)),
+		signal:   make(chan struct{}, 1),
+		done:     make(chan struct{}),
+		join:     make(chan struct{}),
+	}
+	for _, name := range names {
+		p.names[name] = struct{}{}
+	}
+	return p
+}
+
+func (p *priorityFlush) match(measures []stats.Measure) bool {
+	for _, m := range measures {
+		for _, f := range m.Fields {
+			if _, ok := p.names[metricName(m, f)]; ok {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// acquire returns true if the caller is allowed to flush now, which is the
+// case if no other flush happened during the configured interval.
+func (p *priorityFlush) acquire() bool {
+	t := time.Now().UnixNano()
+
+	for {
+		last := atomic.LoadInt64(&p.last)
+
+		if (t - last) < p.interval {
+			return false
+		}
+
+		if atomic.CompareAndSwapInt64(&p.last, last, t) {
+			return true
+		}
+	}
+}
+
+func (p *priorityFlush) reset(now time.Time) {
+	atomic.StoreInt64(&p.last, now.UnixNano())
+}
+
+// notify signals the background goroutine that a flush is due, a flush which
+// is already pending covers the metrics produced since it was signaled.
+func (p *priorityFlush) notify() {
+	select {
+	case p.signal <- struct{}{}:
+	default:
+	}
+}
+
+// run calls flush when notified, until stop is called.
+func (p *priorityFlush) run(flush func()) {
+	defer close(p.join)
+
+	for {
+		select {
+		case <-p.signal:
+			flush()
+		case <-p.done:
+			return
+		}
+	}
+}
+
+// stop terminates the background goroutine, waiting for the flush it may be
+// running to complete.
+func (p *priorityFlush) stop() {
+	p.once.Do(func() { close(p.done) })
+	<-p.join
+}
+
+// metricName returns the name of the dogstatsd metric generated for the field
+// f of the measure m.
+func metricName(m stats.Measure, f stats.Field) string {
+	if len(f.Name) == 0 {
+		return m.Name
+	}
+	return m.Name + "." + f.Name
+}
@@ -0,0 +1,36 @@
+package datadog
+
+import (
+	"fmt"
+	"os"
+)
+
+// ProfileEnv is the name of the environment variable that NewClientProfile
+// reads the profile name from when none is given.
+const ProfileEnv = "STATS_PROFILE"
+
+// NewClientProfile creates and returns a new datadog client configured with
+// the profile identified by name in profiles. This makes it possible to define
+// the settings of each environment (dev, staging, prod...) in a single place
+// and select the one to apply when the program starts.
+//
+// If name is empty, the profile name is read from the STATS_PROFILE
+// environment variable.
+//
+// If no profile exists for the name, the error is logged and the returned
+// client discards all metrics, the error is also returned by its Close
+// method.
+func NewClientProfile(name string, profiles map[string]ClientConfig) *Client {
+	if len(name) == 0 {
+		name = os.Getenv(ProfileEnv)
+	}
+
+	config, ok := profiles[name]
+	if !ok {
+		config = ClientConfig{
+			DialFunc: dialError(fmt.Errorf("datadog: no client profile named %q", name)),
+		}
+	}
+
+	return NewClientWith(config)
+}
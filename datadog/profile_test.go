@@ -0,0 +1,43 @@
+package datadog
+
+import (
+	"os"
+	"testing"
+)
+
+func TestNewClientProfile(t *testing.T) {
+	profiles := map[string]ClientConfig{
+		"dev": {
+			Address:    DefaultAddress,
+			BufferSize: 512,
+		},
+		"prod": {
+			Address:            DefaultAddress,
+			BufferSize:         1024,
+			SignificantFigures: 3,
+		},
+	}
+
+	c1 := NewClientProfile("prod", profiles)
+	defer c1.Close()
+
+	if c1.buffer.BufferSize != 1024 || c1.format().significantFigures != 3 {
+		t.Error("the prod profile was not applied")
+	}
+
+	os.Setenv(ProfileEnv, "dev")
+	defer os.Unsetenv(ProfileEnv)
+
+	c2 := NewClientProfile("", profiles)
+	defer c2.Close()
+
+	if c2.buffer.BufferSize != 512 || c2.format().significantFigures != 0 {
+		t.Error("the dev profile was not selected from the environment")
+	}
+
+	c3 := NewClientProfile("staging", profiles)
+
+	if err := c3.Close(); err == nil {
+		t.Error("expected an error for the unknown profile")
+	}
+}
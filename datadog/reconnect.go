@@ -0,0 +1,135 @@
+package datadog
+
+import (
+	"fmt"
+	"net"
+	"sync"
+	"sync/atomic"
+	"time"
+)
+
+const (
+	// Number of consecutive failed writes after which a client configured
+	// with MaxReconnects replaces its connection.
+	reconnectThreshold = 3
+
+	// Bounds of the delay between two reconnection attempts.
+	minReconnectDelay = 100 * time.Millisecond
+	maxReconnectDelay = 10 * time.Second
+)
+
+// connection wraps the net.Conn of a serializer, atomic.Value requires all
+// stored values to have the same concrete type.
+type connection struct {
+	net.Conn
+}
+
+// dialResult wraps the error of the last dial of a serializer, for the same
+// reason as connection.
+type dialResult struct {
+	err error
+}
+
+// reconnector re-establishes the connection of clients configured with
+// MaxReconnects.
+type reconnector struct {
+	failures int32 // consecutive failed writes, accessed atomically
+	mutex    sync.Mutex
+	dial     func() (net.Conn, int, error)
+	max      int       // consecutive failed attempts before giving up
+	attempts int       // consecutive failed attempts so far
+	next     time.Time // earliest time of the next attempt
+	closed   bool
+}
+
+// record tracks the result of a write, it returns true when the connection
+// must be replaced.
+func (r *reconnector) record(err error) bool {
+	if err == nil {
+		if atomic.LoadInt32(&r.failures) != 0 {
+			atomic.StoreInt32(&r.failures, 0)
+		}
+		return false
+	}
+	return atomic.AddInt32(&r.failures, 1) >= reconnectThreshold
+}
+
+func (s *serializer) connection() net.Conn {
+	if c, _ := s.conn.Load().(connection); c.Conn != nil {
+		return c.Conn
+	}
+	return nil
+}
+
+func (s *serializer) setConnection(conn net.Conn) {
+	s.conn.Store(connection{conn})
+}
+
+// dialError returns the error of the last attempt at establishing the
+// connection, it is nil once a reconnection succeeded.
+func (s *serializer) dialError() error {
+	r, _ := s.dialErr.Load().(dialResult)
+	return r.err
+}
+
+func (s *serializer) setDialError(err error) {
+	s.dialErr.Store(dialResult{err})
+}
+
+// setMaxBufferSize applies the limit of the socket of a new connection, the
+// packet size is lowered if it exceeds it.
+func (s *serializer) setMaxBufferSize(n int) {
+	atomic.StoreInt64(&s.maxBufferSize, int64(n))
+
+	if atomic.LoadInt64(&s.bufferSize) > int64(n) {
+		atomic.StoreInt64(&s.bufferSize, int64(n))
+	}
+}
+
+// reconnect replaces old with a new connection and returns it. If another
+// goroutine already replaced old, the current connection is returned instead,
+// and old is returned if the attempt failed or was not allowed yet.
+func (s *serializer) reconnect(old net.Conn) net.Conn {
+	r := s.reconnector
+	r.mutex.Lock()
+	defer r.mutex.Unlock()
+
+	if conn := s.connection(); conn != old {
+		return conn
+	}
+
+	now := time.Now()
+
+	if r.closed || r.attempts >= r.max || now.Before(r.next) {
+		return old
+	}
+
+	conn, bufsize, err := r.dial()
+	if err != nil {
+		delay := minReconnectDelay << uint(r.attempts)
+		if delay > maxReconnectDelay || delay <= 0 {
+			delay = maxReconnectDelay
+		}
+
+		if r.attempts++; r.attempts == r.max {
+			s.handleError(fmt.Errorf("datadog: giving up reconnecting after %d attempts: %w", r.attempts, err))
+		} else {
+			s.handleError(fmt.Errorf("datadog: reconnecting: %w (next attempt in %s)", err, delay))
+		}
+
+		r.next = now.Add(delay)
+		return old
+	}
+
+	r.attempts, r.next = 0, time.Time{}
+	atomic.StoreInt32(&r.failures, 0)
+	s.setMaxBufferSize(bufsize)
+	s.setConnection(conn)
+	s.setDialError(nil)
+
+	if old != nil {
+		old.Close()
+	}
+
+	return conn
+}
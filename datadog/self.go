@@ -0,0 +1,123 @@
+package datadog
+
+import (
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// SelfMetricsPrefix is the prefix of the metrics that clients configured with
+// SelfMetrics report on their own health. The metrics are sent every time the
+// client is flushed, or every SelfMetricsSampling flushes:
+//
+//	stats.client.alive (gauge)
+//	  The unix time of the flush, in seconds. Monitors can compute how stale
+//	  the value is and alert when the client stops flushing.
+//
+//	stats.client.boolean_violations (counter)
+//	  The number of values of BooleanMetrics which were neither 0 nor 1.
+//
+//	stats.client.values_clamped (counter)
+//	  The number of values exceeding MaxValue which were clamped.
+//
+//	stats.client.values_dropped (counter)
+//	  The number of values exceeding MaxValue which were dropped.
+//
+// Clients configured with a SelfMetricsHeartbeat name also send a gauge of
+// this name whose value is the number of metrics written since the previous
+// emission. A zero value means that the client is alive but idle, while the
+// absence of the metric means that the client stopped flushing.
+const SelfMetricsPrefix = "stats.client."
+
+// counters is a set of counters maintained by the client, they are reported
+// as self metrics. All fields are accessed atomically.
+type counters struct {
+	booleanViolations uint64
+	clampedValues     uint64
+	droppedValues     uint64
+	metrics           uint64
+	datagrams         uint64
+	bytes             uint64
+	oversizedMetrics  uint64
+	writeErrors       uint64
+	flushDuration     uint64 // nanoseconds spent in the last flush
+}
+
+func (c *counters) load() counters {
+	return counters{
+		booleanViolations: atomic.LoadUint64(&c.booleanViolations),
+		clampedValues:     atomic.LoadUint64(&c.clampedValues),
+		droppedValues:     atomic.LoadUint64(&c.droppedValues),
+		metrics:           atomic.LoadUint64(&c.metrics),
+		datagrams:         atomic.LoadUint64(&c.datagrams),
+		bytes:             atomic.LoadUint64(&c.bytes),
+		oversizedMetrics:  atomic.LoadUint64(&c.oversizedMetrics),
+		writeErrors:       atomic.LoadUint64(&c.writeErrors),
+		flushDuration:     atomic.LoadUint64(&c.flushDuration),
+	}
+}
+
+type selfMetrics struct {
+	mutex     sync.Mutex
+	b         []byte
+	last      counters // values of the counters at the previous emission
+	sampling  int      // emit the metrics every N flushes
+	flushes   int      // flushes since the previous emission
+	heartbeat string   // name of the metric count gauge, empty if disabled
+}
+
+func (sm *selfMetrics) flush(s *serializer, now time.Time) {
+	sm.mutex.Lock()
+	defer sm.mutex.Unlock()
+
+	if sm.flushes++; sm.flushes < sm.sampling {
+		return
+	}
+	sm.flushes = 0
+
+	// The corrections configured on the client don't apply to self metrics,
+	// their values would stop being meaningful (the unix time rounded to a few
+	// significant figures would not advance between flushes for example).
+	f := *s.format()
+	f.significantFigures = 0
+	f.booleans = nil
+	f.maxValue = 0
+	f.countMetrics = false
+
+	b := sm.b[:0]
+	b = f.appendMeasure(b, stats.Measure{
+		Name: SelfMetricsPrefix + "alive",
+		Fields: []stats.Field{
+			stats.MakeField("", float64(now.UnixNano())/1e9, stats.Gauge),
+		},
+	})
+
+	c := s.counters.load()
+
+	if len(sm.heartbeat) != 0 {
+		b = f.appendMeasure(b, stats.Measure{
+			Name:   sm.heartbeat,
+			Fields: []stats.Field{stats.MakeField("", c.metrics-sm.last.metrics, stats.Gauge)},
+		})
+	}
+
+	b = appendSelfCounter(b, f, "boolean_violations", c.booleanViolations-sm.last.booleanViolations)
+	b = appendSelfCounter(b, f, "values_clamped", c.clampedValues-sm.last.clampedValues)
+	b = appendSelfCounter(b, f, "values_dropped", c.droppedValues-sm.last.droppedValues)
+	sm.last = c
+
+	s.Write(b)
+	sm.b = b
+}
+
+func appendSelfCounter(b []byte, f format, name string, n uint64) []byte {
+	if n == 0 {
+		return b
+	}
+	return f.appendMeasure(b, stats.Measure{
+		Name:   SelfMetricsPrefix + name,
+		Fields: []stats.Field{stats.MakeField("", n, stats.Counter)},
+	})
+}
@@ -36,10 +36,15 @@ func (f HandlerFunc) HandleEvent(e Event, a net.Addr) {
 
 // ListenAndServe starts a new dogstatsd server, listening for UDP datagrams on
 // addr and forwarding the metrics to handler.
+//
+// Like the addresses of clients, addr may be prefixed with a network scheme to
+// listen on a unix datagram socket, for example unixgram:///tmp/dsd.socket.
 func ListenAndServe(addr string, handler Handler) (err error) {
 	var conn net.PacketConn
 
-	if conn, err = net.ListenPacket("udp", addr); err != nil {
+	network, addr := splitNetworkAddress(addr)
+
+	if conn, err = net.ListenPacket(network, addr); err != nil {
 		return
 	}
 
@@ -2,7 +2,11 @@ package datadog
 
 import (
 	"io"
+	"io/ioutil"
 	"net"
+	"os"
+	"path/filepath"
+	"reflect"
 	"sync/atomic"
 	"testing"
 	"time"
@@ -68,6 +72,57 @@ func TestServer(t *testing.T) {
 	}
 }
 
+func TestServerUnixgram(t *testing.T) {
+	dir, err := ioutil.TempDir("", "datadog")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+
+	path := filepath.Join(dir, "dsd.socket")
+	metrics := make(chan Metric, 10)
+
+	go ListenAndServe("unixgram://"+path, HandlerFunc(func(m Metric, _ net.Addr) {
+		metrics <- m
+	}))
+
+	// Wait for the server to create the socket.
+	for i := 0; i != 100; i++ {
+		if _, err := os.Stat(path); err == nil {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	conn, err := net.Dial("unixgram", path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+
+	// Multiple metrics of all types in a single datagram.
+	if _, err := io.WriteString(conn, "A:1|c|@0.5|#service:api\nB:2|g\nC:3|h|#service:api,env:test\n"); err != nil {
+		t.Fatal(err)
+	}
+
+	expect := []Metric{
+		{Type: Counter, Name: "A", Value: 1, Rate: 0.5, Tags: []stats.Tag{stats.T("service", "api")}},
+		{Type: Gauge, Name: "B", Value: 2, Rate: 1},
+		{Type: Histogram, Name: "C", Value: 3, Rate: 1, Tags: []stats.Tag{stats.T("service", "api"), stats.T("env", "test")}},
+	}
+
+	for _, m := range expect {
+		select {
+		case found := <-metrics:
+			if !reflect.DeepEqual(found, m) {
+				t.Errorf("bad metric:\nwant: %#v\ngot:  %#v", m, found)
+			}
+		case <-time.After(time.Second):
+			t.Fatal("timeout waiting for metric", m.Name)
+		}
+	}
+}
+
 func startTestServer(t *testing.T, handler Handler) (addr string, closer io.Closer) {
 	conn, err := net.ListenPacket("udp", "127.0.0.1:0")
 
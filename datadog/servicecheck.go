@@ -0,0 +1,41 @@
+package datadog
+
+import (
+	"fmt"
+
+	"github.com/segmentio/stats"
+)
+
+// ServiceCheckStatus is an enumeration providing the available datadog
+// service check statuses.
+type ServiceCheckStatus int
+
+const (
+	ServiceCheckOK       ServiceCheckStatus = 0
+	ServiceCheckWarning  ServiceCheckStatus = 1
+	ServiceCheckCritical ServiceCheckStatus = 2
+	ServiceCheckUnknown  ServiceCheckStatus = 3
+)
+
+// ServiceCheck is a representation of a datadog service check.
+type ServiceCheck struct {
+	Name    string
+	Status  ServiceCheckStatus
+	Ts      int64
+	Host    string
+	Tags    []stats.Tag
+	Message string
+}
+
+// String satisfies the fmt.Stringer interface.
+func (sc ServiceCheck) String() string {
+	return fmt.Sprint(sc)
+}
+
+// Format satisfies the fmt.Formatter interface.
+func (sc ServiceCheck) Format(f fmt.State, _ rune) {
+	buf := bufferPool.Get().(*buffer)
+	buf.b = appendServiceCheck(buf.b[:0], sc)
+	f.Write(buf.b)
+	bufferPool.Put(buf)
+}
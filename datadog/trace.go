@@ -0,0 +1,50 @@
+package datadog
+
+import (
+	"context"
+	"strconv"
+
+	"github.com/segmentio/stats"
+)
+
+const (
+	// TraceIDTag is the name of the tag used by datadog to correlate metrics
+	// with the trace that was active when they were produced.
+	TraceIDTag = "dd.trace_id"
+
+	// SpanIDTag is the name of the tag used by datadog to correlate metrics
+	// with the span that was active when they were produced.
+	SpanIDTag = "dd.span_id"
+)
+
+// TraceExtractor is the signature of functions used to extract the identifiers
+// of the active trace and span from a context.
+//
+// The function must return false if the context carries no trace. Programs
+// provide their own extractor so the package doesn't have to depend on a
+// specific tracer.
+type TraceExtractor func(context.Context) (traceID uint64, spanID uint64, ok bool)
+
+// TraceTags returns the datadog APM correlation tags for the trace carried by
+// ctx, or nil if extract found no trace in the context.
+func TraceTags(ctx context.Context, extract TraceExtractor) []stats.Tag {
+	traceID, spanID, ok := extract(ctx)
+	if !ok {
+		return nil
+	}
+	return []stats.Tag{
+		stats.T(TraceIDTag, strconv.FormatUint(traceID, 10)),
+		stats.T(SpanIDTag, strconv.FormatUint(spanID, 10)),
+	}
+}
+
+// WithTrace returns a copy of eng which sets the datadog APM correlation tags
+// of the trace carried by ctx on all the metrics it produces. If ctx carries
+// no trace, eng is returned unchanged.
+func WithTrace(ctx context.Context, eng *stats.Engine, extract TraceExtractor) *stats.Engine {
+	tags := TraceTags(ctx, extract)
+	if tags == nil {
+		return eng
+	}
+	return eng.WithTags(tags...)
+}
@@ -0,0 +1,52 @@
+package datadog
+
+import (
+	"context"
+	"reflect"
+	"testing"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/statstest"
+)
+
+type testTraceKey struct{}
+
+type testTrace struct {
+	traceID uint64
+	spanID  uint64
+}
+
+func extractTestTrace(ctx context.Context) (uint64, uint64, bool) {
+	t, ok := ctx.Value(testTraceKey{}).(testTrace)
+	return t.traceID, t.spanID, ok
+}
+
+func TestWithTrace(t *testing.T) {
+	h := &statstest.Handler{}
+	eng := stats.NewEngine("test", h)
+
+	ctx := context.WithValue(context.Background(), testTraceKey{}, testTrace{
+		traceID: 1234,
+		spanID:  5678,
+	})
+
+	WithTrace(ctx, eng, extractTestTrace).Incr("A")
+	WithTrace(context.Background(), eng, extractTestTrace).Incr("B")
+
+	measures := h.Measures()
+
+	if len(measures) != 2 {
+		t.Fatal("bad number of measures:", len(measures))
+	}
+
+	if tags := measures[0].Tags; !reflect.DeepEqual(tags, []stats.Tag{
+		stats.T(SpanIDTag, "5678"),
+		stats.T(TraceIDTag, "1234"),
+	}) {
+		t.Error("bad tags on traced measure:", tags)
+	}
+
+	if tags := measures[1].Tags; len(tags) != 0 {
+		t.Error("unexpected tags on untraced measure:", tags)
+	}
+}
@@ -0,0 +1,194 @@
+package stats
+
+import (
+	"fmt"
+	"net/http"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/objconv/json"
+)
+
+// Inspector is implemented by handlers which expose their internal state in
+// the documents served by DebugHandler.
+type Inspector interface {
+	// Inspect returns a snapshot of the internal state of the handler, it
+	// must be encodable to JSON.
+	Inspect() interface{}
+}
+
+// DebugHandler returns a http.Handler which renders the state of eng as JSON:
+// the metrics it produced with their type, tags, current value and number of
+// samples, and the internal state of the handlers of eng which implement the
+// Inspector interface.
+//
+// The function registers a handler on eng to record the metrics, it must be
+// called before engines are derived from eng with WithPrefix or WithTags so
+// their measures are recorded as well. One entry is kept for each metric name
+// and set of tags produced by the program.
+//
+// Similarly to net/http/pprof, programs would typically register the handler
+// on their debug server:
+//
+//	http.Handle("/debug/stats", stats.DebugHandler(stats.DefaultEngine))
+//
+// The state of datadog clients is part of the document, datadog.DebugHandler
+// renders it alone and is mounted under /debug/stats/datadog.
+func DebugHandler(eng *Engine) http.Handler {
+	h := &debugHandler{
+		eng:     eng,
+		metrics: make(map[string]*DebugMetric),
+	}
+	eng.Register(h)
+	return h
+}
+
+// DebugState is the document served by DebugHandler.
+type DebugState struct {
+	// Metrics produced by the engine, sorted by name, type and tags.
+	Metrics []DebugMetric `json:"metrics"`
+
+	// State of the handlers of the engine which implement Inspector.
+	Handlers []DebugHandlerState `json:"handlers,omitempty"`
+}
+
+// DebugMetric is the state of a metric in the document served by
+// DebugHandler.
+type DebugMetric struct {
+	Name string            `json:"name"`
+	Type string            `json:"type"`
+	Tags map[string]string `json:"tags,omitempty"`
+
+	// Value is the sum of the increments of counters, scaled by the inverse
+	// of their sample rate, and the last value reported for other metric
+	// types.
+	Value float64 `json:"value"`
+
+	// Number of measures reported for the metric, and time of the last one.
+	Count uint64    `json:"count"`
+	Time  time.Time `json:"time"`
+}
+
+// DebugHandlerState is the state of a handler in the document served by
+// DebugHandler.
+type DebugHandlerState struct {
+	Type  string      `json:"type"`
+	State interface{} `json:"state"`
+}
+
+type debugHandler struct {
+	eng     *Engine
+	mutex   sync.Mutex
+	metrics map[string]*DebugMetric
+}
+
+func (h *debugHandler) HandleMeasures(time time.Time, measures ...Measure) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, m := range measures {
+		tags := tagSetKey(m.Tags)
+
+		for _, f := range m.Fields {
+			name := m.Name
+			if len(f.Name) != 0 {
+				name += "." + f.Name
+			}
+
+			ftype := f.Type()
+			key := name + "\x00" + ftype.String() + "\x00" + tags
+
+			metric := h.metrics[key]
+			if metric == nil {
+				metric = &DebugMetric{Name: name, Type: ftype.String()}
+
+				if len(m.Tags) != 0 {
+					metric.Tags = make(map[string]string, len(m.Tags))
+					for _, t := range m.Tags {
+						metric.Tags[t.Name] = t.Value
+					}
+				}
+
+				h.metrics[key] = metric
+			}
+
+			if value := debugValue(f.Value); ftype == Counter {
+				if m.SampleRate > 0 && m.SampleRate < 1 {
+					value /= m.SampleRate
+				}
+				metric.Value += value
+			} else {
+				metric.Value = value
+			}
+
+			metric.Count++
+
+			if time.After(metric.Time) {
+				metric.Time = time
+			}
+		}
+	}
+}
+
+func (h *debugHandler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
+	state := DebugState{Metrics: h.snapshot()}
+
+	forEachHandler(h.eng.Handler, func(handler Handler) {
+		if i, ok := handler.(Inspector); ok {
+			state.Handlers = append(state.Handlers, DebugHandlerState{
+				Type:  fmt.Sprintf("%T", handler),
+				State: i.Inspect(),
+			})
+		}
+	})
+
+	res.Header().Set("Content-Type", "application/json; charset=utf-8")
+	json.NewPrettyEncoder(res).Encode(state)
+}
+
+func (h *debugHandler) snapshot() []DebugMetric {
+	h.mutex.Lock()
+	keys := make([]string, 0, len(h.metrics))
+	for key := range h.metrics {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	metrics := make([]DebugMetric, len(keys))
+	for i, key := range keys {
+		metrics[i] = *h.metrics[key]
+	}
+	h.mutex.Unlock()
+	return metrics
+}
+
+func forEachHandler(h Handler, do func(Handler)) {
+	if m, ok := h.(*multiHandler); ok {
+		for _, h := range m.handlers {
+			forEachHandler(h, do)
+		}
+	} else if h != nil {
+		do(h)
+	}
+}
+
+func debugValue(v Value) float64 {
+	switch v.Type() {
+	case Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case Int:
+		return float64(v.Int())
+	case Uint:
+		return float64(v.Uint())
+	case Float:
+		return v.Float()
+	case Duration:
+		return v.Duration().Seconds()
+	default:
+		return 0
+	}
+}
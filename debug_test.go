@@ -0,0 +1,92 @@
+package stats_test
+
+import (
+	"net/http/httptest"
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/segmentio/objconv/json"
+	"github.com/segmentio/stats"
+)
+
+type inspectedHandler struct{}
+
+func (inspectedHandler) HandleMeasures(time.Time, ...stats.Measure) {}
+
+func (inspectedHandler) Inspect() interface{} {
+	return map[string]int{"flushes": 1}
+}
+
+func TestDebugHandler(t *testing.T) {
+	eng := stats.NewEngine("test", inspectedHandler{})
+	handler := stats.DebugHandler(eng)
+
+	eng.Incr("requests", stats.T("path", "/"))
+	eng.Add("requests", 2, stats.T("path", "/"))
+	eng.Set("connections", 3)
+	eng.Set("connections", 5)
+	eng.Observe("rtt", 100*time.Millisecond)
+
+	// Sampled counters are scaled by the inverse of their sample rate.
+	eng.Handler.HandleMeasures(time.Now(), stats.Measure{
+		Name:       "test.requests",
+		Fields:     []stats.Field{stats.MakeField("", 1, stats.Counter)},
+		Tags:       []stats.Tag{stats.T("path", "/")},
+		SampleRate: 0.5,
+	})
+
+	res := httptest.NewRecorder()
+	handler.ServeHTTP(res, httptest.NewRequest("GET", "/debug/stats", nil))
+
+	if contentType := res.Header().Get("Content-Type"); contentType != "application/json; charset=utf-8" {
+		t.Error("bad content type:", contentType)
+	}
+
+	var state struct {
+		Metrics []struct {
+			Name  string            `json:"name"`
+			Type  string            `json:"type"`
+			Tags  map[string]string `json:"tags"`
+			Value float64           `json:"value"`
+			Count uint64            `json:"count"`
+		} `json:"metrics"`
+		Handlers []struct {
+			Type  string         `json:"type"`
+			State map[string]int `json:"state"`
+		} `json:"handlers"`
+	}
+
+	if err := json.Unmarshal(res.Body.Bytes(), &state); err != nil {
+		t.Fatal(err)
+	}
+
+	type metric struct {
+		name  string
+		mtype string
+		tags  map[string]string
+		value float64
+		count uint64
+	}
+
+	var found []metric
+	for _, m := range state.Metrics {
+		found = append(found, metric{m.Name, m.Type, m.Tags, m.Value, m.Count})
+	}
+
+	expected := []metric{
+		{"test.connections", "gauge", nil, 5, 2},
+		{"test.requests", "counter", map[string]string{"path": "/"}, 5, 3},
+		{"test.rtt", "histogram", nil, 0.1, 1},
+	}
+
+	if !reflect.DeepEqual(found, expected) {
+		t.Error("bad metrics:")
+		t.Logf("expected: %+v", expected)
+		t.Logf("found:    %+v", found)
+	}
+
+	if len(state.Handlers) != 1 || state.Handlers[0].Type != "stats_test.inspectedHandler" || state.Handlers[0].State["flushes"] != 1 {
+		t.Errorf("bad handlers: %+v", state.Handlers)
+	}
+}
@@ -1,6 +1,7 @@
 package stats
 
 import (
+	"math/rand"
 	"os"
 	"path/filepath"
 	"reflect"
@@ -29,6 +30,25 @@ type Engine struct {
 	// that manipulates this field directly has to respect this requirement.
 	Tags []Tag
 
+	// SampleRate is the probability with which the measures produced by the
+	// engine are recorded, a value between zero and one. The other measures
+	// are dropped, and the rate is set on the recorded ones so handlers can
+	// scale them back up. Zero, like one, disables sampling.
+	//
+	// Sampling reduces the overhead of metrics produced on hot paths, it
+	// applies to all methods producing a single measure, like Incr or Observe,
+	// but not to Report.
+	//
+	// The handlers of this module account for the rate: datadog clients send
+	// it to the agent, while the API client, prometheus, graphite, logstats
+	// and DebugHandler scale counters, and the histogram counts they report,
+	// by the inverse of the rate. The influxdb client writes points for each
+	// measure, it scales counters and writes other fields as they were
+	// observed. WriteCSV writes the rate in the sample column. Handlers
+	// implemented outside of this module must do the same, or the engines
+	// feeding them must not be sampled.
+	SampleRate float64
+
 	// This cache keeps track of the generated measure structures to avoid
 	// rebuilding them every time a same measure type is seen by the engine.
 	//
@@ -66,12 +86,22 @@ func (eng *Engine) Flush() {
 // argument. Both eng and the returned engine share the same handler.
 func (eng *Engine) WithPrefix(prefix string, tags ...Tag) *Engine {
 	return &Engine{
-		Handler: eng.Handler,
-		Prefix:  eng.makeName(prefix),
-		Tags:    eng.makeTags(tags),
+		Handler:    eng.Handler,
+		Prefix:     eng.makeName(prefix),
+		Tags:       eng.makeTags(tags),
+		SampleRate: eng.SampleRate,
 	}
 }
 
+// WithSampleRate returns a copy of the engine which only records measures with
+// a probability of rate. Both eng and the returned engine share the same
+// handler, prefix, and tags.
+func (eng *Engine) WithSampleRate(rate float64) *Engine {
+	cpy := eng.WithPrefix("")
+	cpy.SampleRate = rate
+	return cpy
+}
+
 // WithTags returns a copy of the engine with tags set to the merge of eng's
 // current tags and those passed as arguments. Both eng and the returned engine
 // share the same handler.
@@ -119,6 +149,33 @@ func (eng *Engine) ObserveAt(t time.Time, name string, value interface{}, tags .
 	eng.measure(t, name, value, Histogram, tags...)
 }
 
+// Distribution reports value for the distribution identified by name and tags.
+func (eng *Engine) Distribution(name string, value interface{}, tags ...Tag) {
+	eng.measure(time.Now(), name, value, Distribution, tags...)
+}
+
+// DistributionAt reports value for the distribution identified by name and
+// tags.
+func (eng *Engine) DistributionAt(t time.Time, name string, value interface{}, tags ...Tag) {
+	eng.measure(t, name, value, Distribution, tags...)
+}
+
+// Unique reports value for the set identified by name and tags, which counts
+// the unique values it receives.
+//
+// Measures only carry numeric values, programs counting unique strings (like
+// user names) report a hash of the strings, computed with hash/fnv for
+// example.
+func (eng *Engine) Unique(name string, value int64, tags ...Tag) {
+	eng.measure(time.Now(), name, value, SetType, tags...)
+}
+
+// UniqueAt reports value for the set identified by name and tags, which counts
+// the unique values it receives.
+func (eng *Engine) UniqueAt(t time.Time, name string, value int64, tags ...Tag) {
+	eng.measure(t, name, value, SetType, tags...)
+}
+
 // Clock returns a new clock identified by name and tags.
 func (eng *Engine) Clock(name string, tags ...Tag) *Clock {
 	return eng.ClockAt(name, time.Now(), tags...)
@@ -139,6 +196,19 @@ func (eng *Engine) ClockAt(name string, start time.Time, tags ...Tag) *Clock {
 }
 
 func (eng *Engine) measure(t time.Time, name string, value interface{}, ftype FieldType, tags ...Tag) {
+	eng.measureWith(t, name, value, ftype, nil, tags)
+}
+
+// measureWith is like measure but also sets ctxTags on the measure, which
+// avoids allocating a slice to concatenate them with tags.
+func (eng *Engine) measureWith(t time.Time, name string, value interface{}, ftype FieldType, ctxTags []Tag, tags []Tag) {
+	rate := eng.SampleRate
+	if rate <= 0 || rate >= 1 {
+		rate = 0
+	} else if rand.Float64() >= rate {
+		return
+	}
+
 	name, field := splitMeasureField(name)
 	mp := measureArrayPool.Get().(*[1]Measure)
 
@@ -146,9 +216,11 @@ func (eng *Engine) measure(t time.Time, name string, value interface{}, ftype Fi
 	m.Name = eng.makeName(name) // TODO: figure out how to optimize this
 	m.Fields = append(m.Fields[:0], MakeField(field, value, ftype))
 	m.Tags = append(m.Tags[:0], eng.Tags...)
+	m.Tags = append(m.Tags, ctxTags...)
 	m.Tags = append(m.Tags, tags...)
+	m.SampleRate = rate
 
-	if len(tags) != 0 && !TagsAreSorted(m.Tags) {
+	if (len(ctxTags) != 0 || len(tags) != 0) && !TagsAreSorted(m.Tags) {
 		SortTags(m.Tags)
 	}
 
@@ -163,6 +235,7 @@ func (eng *Engine) measure(t time.Time, name string, value interface{}, ftype Fi
 	}
 
 	m.Name = ""
+	m.SampleRate = 0
 	measureArrayPool.Put(mp)
 }
 
@@ -246,6 +319,13 @@ func WithTags(tags ...Tag) *Engine {
 	return DefaultEngine.WithTags(tags...)
 }
 
+// WithSampleRate returns a copy of the default engine which only records
+// measures with a probability of rate. Both the default engine and the
+// returned engine share the same handler.
+func WithSampleRate(rate float64) *Engine {
+	return DefaultEngine.WithSampleRate(rate)
+}
+
 // Incr increments by one the counter identified by name and tags.
 func Incr(name string, tags ...Tag) {
 	DefaultEngine.Incr(name, tags...)
@@ -286,6 +366,16 @@ func ObserveAt(time time.Time, name string, value interface{}, tags ...Tag) {
 	DefaultEngine.ObserveAt(time, name, value, tags...)
 }
 
+// Unique reports value for the set identified by name and tags.
+func Unique(name string, value int64, tags ...Tag) {
+	DefaultEngine.Unique(name, value, tags...)
+}
+
+// UniqueAt reports value for the set identified by name and tags.
+func UniqueAt(time time.Time, name string, value int64, tags ...Tag) {
+	DefaultEngine.UniqueAt(time, name, value, tags...)
+}
+
 // Report is a helper function that delegates to DefaultEngine.
 func Report(metrics interface{}, tags ...Tag) {
 	DefaultEngine.Report(metrics, tags...)
@@ -2,6 +2,7 @@ package stats_test
 
 import (
 	"io/ioutil"
+	"math"
 	"net/http"
 	"reflect"
 	"strings"
@@ -48,6 +49,18 @@ func TestEngine(t *testing.T) {
 			scenario: "calling Engine.Observe produces the expected histogram value",
 			function: testEngineObserve,
 		},
+		{
+			scenario: "calling Engine.Distribution produces the expected distribution value",
+			function: testEngineDistribution,
+		},
+		{
+			scenario: "calling Engine.Unique produces the expected set value",
+			function: testEngineUnique,
+		},
+		{
+			scenario: "calling Engine.WithSampleRate returns a copy of the engine which only records a fraction of the measures",
+			function: testEngineWithSampleRate,
+		},
 		{
 			scenario: "calling Engine.Report produces the expected measures",
 			function: testEngineReport,
@@ -194,6 +207,75 @@ func testEngineObserve(t *testing.T, eng *stats.Engine) {
 	)
 }
 
+func testEngineDistribution(t *testing.T, eng *stats.Engine) {
+	eng.Distribution("measure.size", 42)
+	eng.Distribution("measure.size", 10, stats.T("type", "testing"))
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test.measure.size",
+			Fields: []stats.Field{stats.MakeField("", 42, stats.Distribution)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+		stats.Measure{
+			Name:   "test.measure.size",
+			Fields: []stats.Field{stats.MakeField("", 10, stats.Distribution)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("type", "testing")},
+		},
+	)
+}
+
+func testEngineUnique(t *testing.T, eng *stats.Engine) {
+	eng.Unique("measure.user", 42)
+	eng.Unique("measure.user", 10, stats.T("type", "testing"))
+
+	// Values are integers so large ones, like hashes, are not rounded.
+	eng.Unique("measure.user", math.MaxInt64)
+
+	checkMeasuresEqual(t, eng,
+		stats.Measure{
+			Name:   "test.measure.user",
+			Fields: []stats.Field{stats.MakeField("", 42, stats.SetType)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+		stats.Measure{
+			Name:   "test.measure.user",
+			Fields: []stats.Field{stats.MakeField("", 10, stats.SetType)},
+			Tags:   []stats.Tag{stats.T("service", "test-service"), stats.T("type", "testing")},
+		},
+		stats.Measure{
+			Name:   "test.measure.user",
+			Fields: []stats.Field{stats.MakeField("", int64(math.MaxInt64), stats.SetType)},
+			Tags:   []stats.Tag{stats.T("service", "test-service")},
+		},
+	)
+}
+
+func testEngineWithSampleRate(t *testing.T, eng *stats.Engine) {
+	const N = 1000
+	sampled := eng.WithSampleRate(0.25)
+
+	for i := 0; i != N; i++ {
+		sampled.Incr("measure.count")
+	}
+
+	measures := eng.Handler.(*statstest.Handler).Measures()
+
+	if n := len(measures); n < N/8 || n > N/2 {
+		t.Errorf("bad number of sampled measures: %d/%d", n, N)
+	}
+
+	for _, m := range measures {
+		if m.SampleRate != 0.25 || m.Name != "test.measure.count" {
+			t.Fatalf("bad sampled measure: %+v", m)
+		}
+	}
+
+	if sub := sampled.WithPrefix("sub"); sub.SampleRate != 0.25 {
+		t.Error("the sample rate was not inherited by the sub-engine:", sub.SampleRate)
+	}
+}
+
 func testEngineReport(t *testing.T, eng *stats.Engine) {
 	m := struct {
 		Count int `metric:"count" type:"counter"`
@@ -53,6 +53,15 @@ const (
 
 	// Histogram represents metrics to observe the distribution of values.
 	Histogram
+
+	// Distribution represents metrics to observe the global distribution of
+	// values, aggregated server-side across all hosts by backends that
+	// support it. Other backends treat distributions as histograms.
+	Distribution
+
+	// SetType represents metrics counting the unique values observed during
+	// a flush interval. The name Set is taken by the gauge setter.
+	SetType
 )
 
 func (t FieldType) String() string {
@@ -63,6 +72,10 @@ func (t FieldType) String() string {
 		return "gauge"
 	case Histogram:
 		return "histogram"
+	case Distribution:
+		return "distribution"
+	case SetType:
+		return "set"
 	}
 	return ""
 }
@@ -75,6 +88,10 @@ func (t FieldType) GoString() string {
 		return "stats.Gauge"
 	case Histogram:
 		return "stats.Histogram"
+	case Distribution:
+		return "stats.Distribution"
+	case SetType:
+		return "stats.SetType"
 	default:
 		return "stats.FieldType(" + strconv.Itoa(int(t)) + ")"
 	}
@@ -0,0 +1,369 @@
+package graphite
+
+import (
+	"log"
+	"net"
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+const (
+	// DefaultAddress is the default address to which the graphite client tries
+	// to connect to.
+	DefaultAddress = "localhost:2003"
+
+	// DefaultBufferSize is the default size for batches of metrics sent to
+	// graphite.
+	DefaultBufferSize = 64 * 1024 // 64 KB
+
+	// DefaultTimeout is the default timeout value used when connecting and
+	// sending metrics to graphite.
+	DefaultTimeout = 5 * time.Second
+
+	// DefaultFlushInterval is the default interval at which graphite clients
+	// write the metrics they aggregated.
+	DefaultFlushInterval = 10 * time.Second
+)
+
+// The ClientConfig type is used to configure graphite clients.
+type ClientConfig struct {
+	// Address of the carbon server to send metrics to, metrics are sent over
+	// TCP in the plaintext protocol.
+	Address string
+
+	// Maximum size of batch of metrics sent to graphite.
+	BufferSize int
+
+	// Maximum amount of time that connecting to and writing metrics to
+	// graphite may take.
+	Timeout time.Duration
+
+	// TagFormat configures how tags of measures are represented in metric
+	// paths, the default is TagPath.
+	TagFormat TagFormat
+
+	// FlushInterval is the interval at which aggregated metrics are written,
+	// the default is DefaultFlushInterval. Negative values disable periodic
+	// writes, the metrics are then only written when the client is flushed.
+	FlushInterval time.Duration
+}
+
+// Client represents a graphite client that implements the stats.Handler
+// interface.
+//
+// Carbon only retains the last point written to a path in each second, so
+// the client aggregates the measures it receives and writes one point per
+// metric when it is flushed. Counters are summed, gauges retain the last
+// value, and histograms are written as the count, avg, min and max of the
+// values they received.
+type Client struct {
+	serializer
+	bufferSize int
+
+	mutex   sync.Mutex
+	metrics map[string]*metric
+
+	once sync.Once
+	done chan struct{}
+	join chan struct{}
+}
+
+// NewClient creates and returns a new graphite client publishing metrics to
+// the carbon server running at addr.
+func NewClient(addr string) *Client {
+	return NewClientWith(ClientConfig{
+		Address: addr,
+	})
+}
+
+// NewClientWith creates and returns a new graphite client configured with the
+// given config.
+func NewClientWith(config ClientConfig) *Client {
+	if len(config.Address) == 0 {
+		config.Address = DefaultAddress
+	}
+
+	if config.BufferSize == 0 {
+		config.BufferSize = DefaultBufferSize
+	}
+
+	if config.Timeout == 0 {
+		config.Timeout = DefaultTimeout
+	}
+
+	if config.FlushInterval == 0 {
+		config.FlushInterval = DefaultFlushInterval
+	}
+
+	c := &Client{
+		serializer: serializer{
+			address:   config.Address,
+			timeout:   config.Timeout,
+			tagFormat: config.TagFormat,
+		},
+		bufferSize: config.BufferSize,
+		metrics:    make(map[string]*metric),
+		done:       make(chan struct{}),
+		join:       make(chan struct{}),
+	}
+
+	if config.FlushInterval > 0 {
+		go c.run(config.FlushInterval)
+	} else {
+		close(c.join)
+	}
+
+	return c
+}
+
+// HandleMeasures satisfies the stats.Handler interface.
+func (c *Client) HandleMeasures(time time.Time, measures ...stats.Measure) {
+	c.mutex.Lock()
+	defer c.mutex.Unlock()
+
+	for _, m := range measures {
+		for _, field := range m.Fields {
+			c.observe(time, m, field)
+		}
+	}
+}
+
+func (c *Client) observe(t time.Time, m stats.Measure, field stats.Field) {
+	name := m.Name
+	if len(field.Name) != 0 {
+		if len(name) != 0 {
+			name += "."
+		}
+		name += field.Name
+	}
+
+	ftype := field.Type()
+
+	k := make([]byte, 0, 128)
+	k = append(k, name...)
+	k = append(k, 0, byte(ftype))
+	for _, tag := range m.Tags {
+		k = append(k, 0)
+		k = append(k, tag.Name...)
+		k = append(k, '=')
+		k = append(k, tag.Value...)
+	}
+
+	x := c.metrics[string(k)]
+	if x == nil {
+		x = &metric{
+			name:  name,
+			ftype: ftype,
+			tags:  append([]stats.Tag(nil), m.Tags...),
+		}
+		c.metrics[string(k)] = x
+	}
+
+	// Sampled measures stand for 1/rate measures, carbon has no sample rates
+	// so they are scaled here.
+	weight := 1.0
+	if m.SampleRate > 0 && m.SampleRate < 1 {
+		weight = 1 / m.SampleRate
+	}
+
+	x.observe(t, floatValue(field.Value), weight)
+}
+
+// Flush writes the metrics aggregated since the last flush, satisfies the
+// stats.Flusher interface.
+func (c *Client) Flush() {
+	c.mutex.Lock()
+	metrics := c.metrics
+	c.metrics = make(map[string]*metric, len(metrics))
+	c.mutex.Unlock()
+
+	keys := make([]string, 0, len(metrics))
+	for k := range metrics {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	// The points are written in batches of up to BufferSize bytes, a batch
+	// which fails to be written is dropped.
+	b := make([]byte, 0, c.bufferSize)
+
+	for _, k := range keys {
+		x := metrics[k]
+		n := len(b)
+		b = c.AppendMeasures(b, x.time, x.measure())
+
+		if len(b) > c.bufferSize && n != 0 {
+			c.Write(b[:n])
+			b = append(b[:0], b[n:]...)
+		}
+	}
+
+	c.Write(b)
+}
+
+// Close stops the periodic writes, flushes and closes the client, satisfies
+// the io.Closer interface.
+func (c *Client) Close() error {
+	c.once.Do(func() { close(c.done) })
+	<-c.join
+	c.Flush()
+	return c.close()
+}
+
+func (c *Client) run(interval time.Duration) {
+	defer close(c.join)
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ticker.C:
+			c.Flush()
+		case <-c.done:
+			return
+		}
+	}
+}
+
+// metric accumulates the values of a metric between two flushes.
+type metric struct {
+	name  string
+	ftype stats.FieldType
+	tags  []stats.Tag
+	time  time.Time
+	count float64
+	sum   float64
+	last  float64
+	min   float64
+	max   float64
+
+	// Values observed by set metrics, which report the number of unique
+	// values.
+	unique map[float64]struct{}
+}
+
+func (x *metric) observe(t time.Time, value float64, weight float64) {
+	if x.count == 0 || value < x.min {
+		x.min = value
+	}
+	if x.count == 0 || value > x.max {
+		x.max = value
+	}
+	if t.After(x.time) {
+		x.time = t
+	}
+	x.count += weight
+	x.sum += value * weight
+	x.last = value
+
+	if x.ftype == stats.SetType {
+		if x.unique == nil {
+			x.unique = make(map[float64]struct{})
+		}
+		x.unique[value] = struct{}{}
+	}
+}
+
+// measure returns the measure representing the points written for x.
+func (x *metric) measure() stats.Measure {
+	m := stats.Measure{Name: x.name, Tags: x.tags}
+	add := func(name string, value float64) {
+		m.Fields = append(m.Fields, stats.MakeField(name, value, stats.Gauge))
+	}
+
+	switch x.ftype {
+	case stats.Counter:
+		add("", x.sum)
+	case stats.Gauge:
+		add("", x.last)
+	case stats.SetType:
+		add("", float64(len(x.unique)))
+	default:
+		add("count", x.count)
+		add("avg", x.sum/x.count)
+		add("min", x.min)
+		add("max", x.max)
+	}
+
+	return m
+}
+
+func floatValue(v stats.Value) float64 {
+	switch v.Type() {
+	case stats.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case stats.Int:
+		return float64(v.Int())
+	case stats.Uint:
+		return float64(v.Uint())
+	case stats.Duration:
+		return v.Duration().Seconds()
+	default:
+		return v.Float()
+	}
+}
+
+type serializer struct {
+	address   string
+	timeout   time.Duration
+	tagFormat TagFormat
+
+	mutex sync.Mutex
+	conn  net.Conn
+}
+
+func (s *serializer) AppendMeasures(b []byte, time time.Time, measures ...stats.Measure) []byte {
+	for _, m := range measures {
+		b = appendMeasure(b, time, m, s.tagFormat)
+	}
+	return b
+}
+
+// Write sends b to the carbon server, the connection is established lazily and
+// re-established on the next write after an error, so a carbon restart only
+// loses the batches written while it was unavailable.
+func (s *serializer) Write(b []byte) (int, error) {
+	if len(b) == 0 {
+		return 0, nil
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn == nil {
+		conn, err := net.DialTimeout("tcp", s.address, s.timeout)
+		if err != nil {
+			log.Printf("stats/graphite: %s", err)
+			return 0, err
+		}
+		s.conn = conn
+	}
+
+	s.conn.SetWriteDeadline(time.Now().Add(s.timeout))
+
+	n, err := s.conn.Write(b)
+	if err != nil {
+		log.Printf("stats/graphite: %s", err)
+		s.conn.Close()
+		s.conn = nil
+	}
+	return n, err
+}
+
+func (s *serializer) close() (err error) {
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+
+	if s.conn != nil {
+		err = s.conn.Close()
+		s.conn = nil
+	}
+	return
+}
@@ -0,0 +1,131 @@
+package graphite
+
+import (
+	"io/ioutil"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+func TestClient(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	output := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			output <- err.Error()
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b, _ := ioutil.ReadAll(conn)
+		output <- string(b)
+	}()
+
+	client := NewClientWith(ClientConfig{
+		Address:   l.Addr().String(),
+		TagFormat: TagSyntax,
+	})
+
+	client.HandleMeasures(time.Unix(1500000000, 0), stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+		},
+		Tags: []stats.Tag{stats.T("method", "GET")},
+	})
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	if s := <-output; s != "request.count;method=GET 1 1500000000\n" {
+		t.Errorf("bad output: %q", s)
+	}
+}
+
+func TestClientAggregatesMeasures(t *testing.T) {
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer l.Close()
+
+	output := make(chan string, 1)
+
+	go func() {
+		conn, err := l.Accept()
+		if err != nil {
+			output <- err.Error()
+			return
+		}
+		defer conn.Close()
+		conn.SetReadDeadline(time.Now().Add(time.Second))
+		b, _ := ioutil.ReadAll(conn)
+		output <- string(b)
+	}()
+
+	client := NewClientWith(ClientConfig{
+		Address:       l.Addr().String(),
+		FlushInterval: -1,
+	})
+
+	for i, rtt := range []time.Duration{100 * time.Millisecond, 300 * time.Millisecond} {
+		client.HandleMeasures(time.Unix(1500000000+int64(i), 0), stats.Measure{
+			Name: "request",
+			Fields: []stats.Field{
+				stats.MakeField("count", 1, stats.Counter),
+				stats.MakeField("inflight", 2-i, stats.Gauge),
+				stats.MakeField("rtt", rtt, stats.Histogram),
+			},
+		})
+	}
+
+	client.HandleMeasures(time.Unix(1500000000, 0), stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("errors", 1, stats.Counter),
+		},
+		SampleRate: 0.25,
+	})
+
+	if err := client.Close(); err != nil {
+		t.Error(err)
+	}
+
+	const expect = "request.count 2 1500000001\n" +
+		"request.errors 4 1500000000\n" +
+		"request.inflight 1 1500000001\n" +
+		"request.rtt.count 2 1500000001\n" +
+		"request.rtt.avg 0.2 1500000001\n" +
+		"request.rtt.min 0.1 1500000001\n" +
+		"request.rtt.max 0.3 1500000001\n"
+
+	if s := <-output; s != expect {
+		t.Errorf("bad output:\n%s", s)
+	}
+}
+
+func TestClientUnreachable(t *testing.T) {
+	client := NewClientWith(ClientConfig{
+		Address: "127.0.0.1:1",
+		Timeout: 100 * time.Millisecond,
+	})
+	defer client.Close()
+
+	if _, err := client.Write([]byte("request.count 1 1500000000\n")); err == nil {
+		t.Error("writing to an unreachable server must fail")
+	}
+
+	if client.conn != nil {
+		t.Error("the client must not keep a connection after a failure")
+	}
+}
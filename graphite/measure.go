@@ -0,0 +1,112 @@
+package graphite
+
+import (
+	"strconv"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// TagFormat is an enumeration of the ways that tags of measures can be
+// represented in graphite metric paths.
+type TagFormat int
+
+const (
+	// TagPath appends tags to the metric path as pairs of name and value
+	// segments, for example request.count.method.GET, which is supported by
+	// all carbon versions.
+	TagPath TagFormat = iota
+
+	// TagSyntax uses the tag syntax introduced in graphite 1.1, for example
+	// request.count;method=GET.
+	TagSyntax
+)
+
+// AppendMeasure is a formatting routine to append the graphite plaintext
+// protocol representation of a measure to a memory buffer, tags are appended
+// to the metric paths.
+func AppendMeasure(b []byte, t time.Time, m stats.Measure) []byte {
+	return appendMeasure(b, t, m, TagPath)
+}
+
+func appendMeasure(b []byte, t time.Time, m stats.Measure, tagFormat TagFormat) []byte {
+	for _, field := range m.Fields {
+		b = appendPath(b, m.Name)
+		if len(field.Name) != 0 {
+			if len(m.Name) != 0 {
+				b = append(b, '.')
+			}
+			b = appendPath(b, field.Name)
+		}
+
+		for _, tag := range m.Tags {
+			switch tagFormat {
+			case TagSyntax:
+				b = append(b, ';')
+				b = appendSegment(b, tag.Name)
+				b = append(b, '=')
+				b = appendSegment(b, tag.Value)
+			default:
+				b = append(b, '.')
+				b = appendSegment(b, tag.Name)
+				b = append(b, '.')
+				b = appendSegment(b, tag.Value)
+			}
+		}
+
+		b = append(b, ' ')
+
+		switch v := field.Value; v.Type() {
+		case stats.Bool:
+			if v.Bool() {
+				b = append(b, '1')
+			} else {
+				b = append(b, '0')
+			}
+		case stats.Int:
+			b = strconv.AppendInt(b, v.Int(), 10)
+		case stats.Uint:
+			b = strconv.AppendUint(b, v.Uint(), 10)
+		case stats.Float:
+			b = strconv.AppendFloat(b, v.Float(), 'g', -1, 64)
+		case stats.Duration:
+			b = strconv.AppendFloat(b, v.Duration().Seconds(), 'g', -1, 64)
+		default:
+			b = append(b, '0')
+		}
+
+		b = append(b, ' ')
+		b = strconv.AppendInt(b, t.Unix(), 10)
+		b = append(b, '\n')
+	}
+
+	return b
+}
+
+// appendPath appends s to b, replacing the bytes that would break the line
+// protocol. Dots are preserved since they separate the path segments.
+func appendPath(b []byte, s string) []byte {
+	for i := 0; i != len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n', ';':
+			b = append(b, '_')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
+
+// appendSegment is like appendPath but also replaces the dots and equal signs
+// so s is always represented as a single path segment or tag component.
+func appendSegment(b []byte, s string) []byte {
+	for i := 0; i != len(s); i++ {
+		switch c := s[i]; c {
+		case ' ', '\t', '\n', ';', '.', '=':
+			b = append(b, '_')
+		default:
+			b = append(b, c)
+		}
+	}
+	return b
+}
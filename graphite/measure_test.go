@@ -0,0 +1,80 @@
+package graphite
+
+import (
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+var (
+	timestamp   = time.Date(2017, 7, 23, 3, 36, 0, 123456789, time.UTC)
+	testMetrics = []struct {
+		m         stats.Measure
+		tagFormat TagFormat
+		s         string
+	}{
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					{Name: "count", Value: stats.ValueOf(5)},
+				},
+			},
+			s: "request.count 5 1500780960\n",
+		},
+
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					{Name: "count", Value: stats.ValueOf(5)},
+					{Name: "rtt", Value: stats.ValueOf(100 * time.Millisecond)},
+				},
+				Tags: []stats.Tag{
+					stats.T("answer", "42"),
+					stats.T("host", "api.example.com"),
+				},
+			},
+			tagFormat: TagPath,
+			s:         "request.count.answer.42.host.api_example_com 5 1500780960\nrequest.rtt.answer.42.host.api_example_com 0.1 1500780960\n",
+		},
+
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					{Name: "count", Value: stats.ValueOf(5)},
+				},
+				Tags: []stats.Tag{
+					stats.T("answer", "42"),
+					stats.T("host", "api.example.com"),
+				},
+			},
+			tagFormat: TagSyntax,
+			s:         "request.count;answer=42;host=api_example_com 5 1500780960\n",
+		},
+
+		{
+			m: stats.Measure{
+				Name: "go routines",
+				Fields: []stats.Field{
+					{Name: "", Value: stats.ValueOf(true)},
+				},
+			},
+			s: "go_routines 1 1500780960\n",
+		},
+	}
+)
+
+func TestAppendMeasure(t *testing.T) {
+	for _, test := range testMetrics {
+		t.Run(test.s, func(t *testing.T) {
+			if s := string(appendMeasure(nil, timestamp, test.m, test.tagFormat)); s != test.s {
+				t.Error("bad metric representation:")
+				t.Log("expected:", test.s)
+				t.Log("found:   ", s)
+			}
+		})
+	}
+}
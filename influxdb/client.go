@@ -42,6 +42,10 @@ type ClientConfig struct {
 	// Name of the InfluxDB database to send metrics to.
 	Database string
 
+	// Name of the retention policy that metrics are written to, the default
+	// retention policy of the database is used if empty.
+	RetentionPolicy string
+
 	// Maximum size of batch of events sent to InfluxDB.
 	BufferSize int
 
@@ -51,6 +55,11 @@ type ClientConfig struct {
 	// Transport configures the HTTP transport used by the client to send
 	// requests to InfluxDB. By default http.DefaultTransport is used.
 	Transport http.RoundTripper
+
+	// ClockSkew is added to the timestamps of all measures before they are
+	// sent to InfluxDB, it may be used to correct a known offset between the
+	// clock of the host and the actual time.
+	ClockSkew time.Duration
 }
 
 // Client represents an InfluxDB client that implements the stats.Handler
@@ -89,8 +98,9 @@ func NewClientWith(config ClientConfig) *Client {
 
 	c := &Client{
 		serializer: serializer{
-			url:  makeURL(config.Address, config.Database),
-			done: make(chan struct{}),
+			url:       makeURL(config.Address, config.Database, config.RetentionPolicy),
+			done:      make(chan struct{}),
+			clockSkew: config.ClockSkew,
 			http: http.Client{
 				Timeout:   config.Timeout,
 				Transport: config.Transport,
@@ -139,13 +149,16 @@ func (c *Client) Close() error {
 }
 
 type serializer struct {
-	url  *url.URL
-	http http.Client
-	once sync.Once
-	done chan struct{}
+	url       *url.URL
+	http      http.Client
+	once      sync.Once
+	done      chan struct{}
+	clockSkew time.Duration
 }
 
-func (*serializer) AppendMeasures(b []byte, time time.Time, measures ...stats.Measure) []byte {
+func (s *serializer) AppendMeasures(b []byte, time time.Time, measures ...stats.Measure) []byte {
+	time = time.Add(s.clockSkew)
+
 	for _, m := range measures {
 		b = AppendMeasure(b, time, m)
 	}
@@ -184,7 +197,7 @@ func (s *serializer) Write(b []byte) (n int, err error) {
 	return
 }
 
-func makeURL(address string, database string) *url.URL {
+func makeURL(address string, database string, retentionPolicy string) *url.URL {
 	if !strings.Contains(address, "://") {
 		address = "http://" + address
 	}
@@ -209,6 +222,11 @@ func makeURL(address string, database string) *url.URL {
 		u.RawQuery = q.Encode()
 	}
 
+	if _, ok := q["rp"]; !ok && len(retentionPolicy) != 0 {
+		q.Set("rp", retentionPolicy)
+		u.RawQuery = q.Encode()
+	}
+
 	return u
 }
 
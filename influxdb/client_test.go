@@ -1,7 +1,9 @@
 package influxdb
 
 import (
+	"bytes"
 	"fmt"
+	"io"
 	"io/ioutil"
 	"net/http"
 	"strings"
@@ -47,6 +49,64 @@ func TestClient(t *testing.T) {
 	}
 }
 
+func TestClientClockSkew(t *testing.T) {
+	transport := &captureTransport{}
+
+	client := NewClientWith(ClientConfig{
+		Address:   DefaultAddress,
+		Transport: transport,
+		ClockSkew: 90 * time.Second,
+	})
+
+	client.HandleMeasures(time.Unix(1500000000, 0), stats.Measure{
+		Name: "request",
+		Fields: []stats.Field{
+			stats.MakeField("count", 1, stats.Counter),
+		},
+	})
+
+	client.Close()
+
+	if body := transport.body.String(); body != "request count=1 1500000090000000000\n" {
+		t.Errorf("bad timestamp in the request body: %q", body)
+	}
+}
+
+func TestMakeURL(t *testing.T) {
+	tests := []struct {
+		address         string
+		database        string
+		retentionPolicy string
+		url             string
+	}{
+		{
+			address:  "localhost:8086",
+			database: "stats",
+			url:      "http://localhost:8086/write?db=stats",
+		},
+		{
+			address:         "localhost:8086",
+			database:        "stats",
+			retentionPolicy: "one_week",
+			url:             "http://localhost:8086/write?db=stats&rp=one_week",
+		},
+		{
+			address:         "https://influx.example.com/write?db=other&rp=forever",
+			database:        "stats",
+			retentionPolicy: "one_week",
+			url:             "https://influx.example.com/write?db=other&rp=forever",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.url, func(t *testing.T) {
+			if u := makeURL(test.address, test.database, test.retentionPolicy); u.String() != test.url {
+				t.Errorf("bad URL:\nwant: %s\ngot:  %s", test.url, u)
+			}
+		})
+	}
+}
+
 func BenchmarkClient(b *testing.B) {
 	for _, N := range []int{1, 10, 100} {
 		b.Run(fmt.Sprintf("write a batch of %d measures to a client", N), func(b *testing.B) {
@@ -99,6 +159,16 @@ func (t *errorCaptureTransport) RoundTrip(req *http.Request) (*http.Response, er
 	return res, err
 }
 
+type captureTransport struct {
+	discardTransport
+	body bytes.Buffer
+}
+
+func (t *captureTransport) RoundTrip(req *http.Request) (*http.Response, error) {
+	io.Copy(&t.body, req.Body)
+	return t.discardTransport.RoundTrip(req)
+}
+
 type discardTransport struct{}
 
 func (t *discardTransport) RoundTrip(req *http.Request) (*http.Response, error) {
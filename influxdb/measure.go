@@ -9,6 +9,9 @@ import (
 
 // AppendMeasure is a formatting routine to append the InflxDB line protocol
 // representation of a measure to a memory buffer.
+//
+// The counters of sampled measures are scaled by the inverse of the sample
+// rate, other fields are written unchanged.
 func AppendMeasure(b []byte, t time.Time, m stats.Measure) []byte {
 	b = append(b, m.Name...)
 
@@ -33,6 +36,11 @@ func AppendMeasure(b []byte, t time.Time, m stats.Measure) []byte {
 		b = append(b, field.Name...)
 		b = append(b, '=')
 
+		if rate := m.SampleRate; field.Type() == stats.Counter && rate > 0 && rate < 1 {
+			b = strconv.AppendFloat(b, floatValue(field.Value)/rate, 'g', -1, 64)
+			continue
+		}
+
 		switch v := field.Value; v.Type() {
 		case stats.Null:
 		case stats.Bool:
@@ -57,3 +65,21 @@ func AppendMeasure(b []byte, t time.Time, m stats.Measure) []byte {
 
 	return append(b, '\n')
 }
+
+func floatValue(v stats.Value) float64 {
+	switch v.Type() {
+	case stats.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case stats.Int:
+		return float64(v.Int())
+	case stats.Uint:
+		return float64(v.Uint())
+	case stats.Duration:
+		return v.Duration().Seconds()
+	default:
+		return v.Float()
+	}
+}
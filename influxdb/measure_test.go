@@ -37,6 +37,18 @@ var (
 			},
 			s: `request,answer=42,hello=world count=5,rtt=0.1 1500780960123456789`,
 		},
+
+		{
+			m: stats.Measure{
+				Name: "request",
+				Fields: []stats.Field{
+					stats.MakeField("count", 5, stats.Counter),
+					stats.MakeField("rtt", 100*time.Millisecond, stats.Histogram),
+				},
+				SampleRate: 0.5,
+			},
+			s: `request count=10,rtt=0.1 1500780960123456789`,
+		},
 	}
 )
 
@@ -0,0 +1,279 @@
+// Package logstats implements a stats handler writing metrics in a human
+// readable or JSON format, which is intended to be used during development to
+// inspect the metrics produced by a program without running an agent.
+package logstats
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+	"sync"
+	"text/tabwriter"
+	"time"
+
+	"github.com/segmentio/objconv/json"
+	"github.com/segmentio/stats"
+)
+
+// Format is an enumeration of the output formats supported by the handler.
+type Format int
+
+const (
+	// Text writes metrics as aligned columns of time, type, name, value, and
+	// tags. This is the default.
+	Text Format = iota
+
+	// JSON writes metrics as newline-delimited JSON objects.
+	JSON
+)
+
+// The Config type is used to configure log handlers.
+type Config struct {
+	// Output is the writer that metrics are written to, the default is
+	// os.Stderr.
+	Output io.Writer
+
+	// Format is the output format of the metrics, the default is Text.
+	Format Format
+
+	// Prefix filters the metrics written by the handler, only those with a
+	// name starting with the prefix are written. If empty, all metrics are
+	// written.
+	Prefix string
+}
+
+// Handler is a stats handler which aggregates the metrics it receives and
+// writes those that changed since the last flush to its output when it is
+// flushed.
+//
+// Between two flushes, the handler keeps one entry for each metric name, type
+// and set of tags. Counters are summed, while gauges and histograms retain the
+// last value they received.
+type Handler struct {
+	output io.Writer
+	format Format
+	prefix string
+
+	mutex   sync.Mutex
+	metrics []*metric
+	index   map[string]*metric
+}
+
+// NewHandler creates and returns a new handler writing metrics in the Text
+// format to os.Stderr.
+func NewHandler() *Handler {
+	return NewHandlerWith(Config{})
+}
+
+// NewHandlerWith creates and returns a new handler configured with config.
+func NewHandlerWith(config Config) *Handler {
+	if config.Output == nil {
+		config.Output = os.Stderr
+	}
+
+	return &Handler{
+		output: config.Output,
+		format: config.Format,
+		prefix: config.Prefix,
+	}
+}
+
+type metric struct {
+	Time  time.Time         `json:"time"`
+	Type  string            `json:"type"`
+	Name  string            `json:"name"`
+	Value interface{}       `json:"value"`
+	Tags  map[string]string `json:"tags,omitempty"`
+
+	value stats.Value
+	tags  []stats.Tag
+}
+
+// HandleMeasures satisfies the stats.Handler interface.
+func (h *Handler) HandleMeasures(time time.Time, measures ...stats.Measure) {
+	h.mutex.Lock()
+	defer h.mutex.Unlock()
+
+	for _, m := range measures {
+		for _, f := range m.Fields {
+			name := m.Name
+			if len(f.Name) != 0 {
+				name += "." + f.Name
+			}
+
+			if !strings.HasPrefix(name, h.prefix) {
+				continue
+			}
+
+			ftype := f.Type()
+			key := metricKey(name, ftype, m.Tags)
+			value := f.Value
+
+			// Sampled counters stand for 1/rate increments.
+			if rate := m.SampleRate; ftype == stats.Counter && rate > 0 && rate < 1 {
+				value = stats.ValueOf(floatValue(value) / rate)
+			}
+
+			if x := h.index[key]; x != nil {
+				if ftype == stats.Counter {
+					x.value = add(x.value, value)
+				} else {
+					x.value = value
+				}
+				if time.After(x.Time) {
+					x.Time = time
+				}
+				continue
+			}
+
+			x := &metric{
+				Time:  time,
+				Type:  ftype.String(),
+				Name:  name,
+				value: value,
+				tags:  append([]stats.Tag(nil), m.Tags...),
+			}
+
+			if h.index == nil {
+				h.index = make(map[string]*metric)
+			}
+
+			h.index[key] = x
+			h.metrics = append(h.metrics, x)
+		}
+	}
+}
+
+func metricKey(name string, ftype stats.FieldType, tags []stats.Tag) string {
+	b := make([]byte, 0, 64)
+	b = append(b, name...)
+	b = append(b, 0, byte(ftype))
+
+	for _, t := range tags {
+		b = append(b, 0)
+		b = append(b, t.Name...)
+		b = append(b, '=')
+		b = append(b, t.Value...)
+	}
+
+	return string(b)
+}
+
+// add returns the sum of two counter increments, the sum is a float unless
+// both have the same type.
+func add(a stats.Value, b stats.Value) stats.Value {
+	if a.Type() != b.Type() {
+		return stats.ValueOf(floatValue(a) + floatValue(b))
+	}
+
+	switch a.Type() {
+	case stats.Int:
+		return stats.ValueOf(a.Int() + b.Int())
+	case stats.Uint:
+		return stats.ValueOf(a.Uint() + b.Uint())
+	case stats.Duration:
+		return stats.ValueOf(a.Duration() + b.Duration())
+	default:
+		return stats.ValueOf(floatValue(a) + floatValue(b))
+	}
+}
+
+func floatValue(v stats.Value) float64 {
+	switch v.Type() {
+	case stats.Bool:
+		if v.Bool() {
+			return 1
+		}
+		return 0
+	case stats.Int:
+		return float64(v.Int())
+	case stats.Uint:
+		return float64(v.Uint())
+	case stats.Duration:
+		return v.Duration().Seconds()
+	default:
+		return v.Float()
+	}
+}
+
+// Flush writes the metrics received since the last flush, satisfies the
+// stats.Flusher interface.
+func (h *Handler) Flush() {
+	h.mutex.Lock()
+	metrics := h.metrics
+	h.metrics, h.index = nil, nil
+	h.mutex.Unlock()
+
+	if len(metrics) == 0 {
+		return
+	}
+
+	switch h.format {
+	case JSON:
+		writeJSON(h.output, metrics)
+	default:
+		writeText(h.output, metrics)
+	}
+}
+
+func writeText(w io.Writer, metrics []*metric) {
+	b := &bytes.Buffer{}
+	tw := tabwriter.NewWriter(b, 0, 4, 2, ' ', 0)
+
+	for _, m := range metrics {
+		tags := make([]string, len(m.tags))
+		for i, t := range m.tags {
+			tags[i] = t.Name + "=" + t.Value
+		}
+
+		fmt.Fprintf(tw, "%s\t%s\t%s\t%s\t%s\n",
+			m.Time.Format(time.RFC3339),
+			m.Type,
+			m.Name,
+			m.value,
+			strings.Join(tags, " "),
+		)
+	}
+
+	tw.Flush()
+
+	// The value column is padded on lines with no tags, the trailing spaces
+	// are trimmed after alignment.
+	out := make([]byte, 0, b.Len())
+	for _, line := range bytes.SplitAfter(b.Bytes(), []byte{'\n'}) {
+		if n := len(line); n != 0 {
+			out = append(out, bytes.TrimRight(line[:n-1], " ")...)
+			out = append(out, '\n')
+		}
+	}
+	w.Write(out)
+}
+
+func writeJSON(w io.Writer, metrics []*metric) {
+	b := &bytes.Buffer{}
+	e := json.NewEncoder(b)
+
+	for _, m := range metrics {
+		switch v := m.value; v.Type() {
+		case stats.Duration:
+			// Durations are represented in seconds, like most backends do.
+			m.Value = v.Duration().Seconds()
+		default:
+			m.Value = v.Interface()
+		}
+
+		if len(m.tags) != 0 {
+			m.Tags = make(map[string]string, len(m.tags))
+			for _, t := range m.tags {
+				m.Tags[t.Name] = t.Value
+			}
+		}
+
+		e.Encode(m)
+		b.WriteByte('\n')
+	}
+
+	w.Write(b.Bytes())
+}
@@ -0,0 +1,137 @@
+package logstats
+
+import (
+	"bytes"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+var (
+	timestamp = time.Date(2017, 7, 23, 3, 36, 0, 0, time.UTC)
+	measures  = []stats.Measure{
+		{
+			Name: "request",
+			Fields: []stats.Field{
+				stats.MakeField("count", 1, stats.Counter),
+				stats.MakeField("rtt", 100*time.Millisecond, stats.Histogram),
+			},
+			Tags: []stats.Tag{stats.T("method", "GET")},
+		},
+		{
+			Name:   "goroutines",
+			Fields: []stats.Field{stats.MakeField("", 42, stats.Gauge)},
+		},
+	}
+)
+
+func TestHandlerText(t *testing.T) {
+	b := &bytes.Buffer{}
+	h := NewHandlerWith(Config{Output: b})
+
+	h.HandleMeasures(timestamp, measures...)
+	h.Flush()
+
+	const expect = `2017-07-23T03:36:00Z  counter    request.count  1      method=GET
+2017-07-23T03:36:00Z  histogram  request.rtt    100ms  method=GET
+2017-07-23T03:36:00Z  gauge      goroutines     42
+`
+
+	if s := b.String(); s != expect {
+		t.Error("bad output:")
+		t.Log("expected:\n" + expect)
+		t.Log("found:\n" + s)
+	}
+
+	b.Reset()
+	h.Flush()
+
+	if b.Len() != 0 {
+		t.Errorf("metrics were written twice: %q", b.String())
+	}
+}
+
+func TestHandlerJSON(t *testing.T) {
+	b := &bytes.Buffer{}
+	h := NewHandlerWith(Config{Output: b, Format: JSON, Prefix: "request."})
+
+	h.HandleMeasures(timestamp, measures...)
+	h.Flush()
+
+	const expect = `{"time":"2017-07-23T03:36:00Z","type":"counter","name":"request.count","value":1,"tags":{"method":"GET"}}
+{"time":"2017-07-23T03:36:00Z","type":"histogram","name":"request.rtt","value":0.1,"tags":{"method":"GET"}}
+`
+
+	if s := b.String(); s != expect {
+		t.Error("bad output:")
+		t.Log("expected:\n" + expect)
+		t.Log("found:\n" + s)
+	}
+}
+
+func TestHandlerAggregate(t *testing.T) {
+	b := &bytes.Buffer{}
+	h := NewHandlerWith(Config{Output: b})
+
+	h.HandleMeasures(timestamp, measures...)
+	h.HandleMeasures(timestamp.Add(time.Second),
+		stats.Measure{
+			Name: "request",
+			Fields: []stats.Field{
+				stats.MakeField("count", 2, stats.Counter),
+				stats.MakeField("rtt", 200*time.Millisecond, stats.Histogram),
+			},
+			Tags: []stats.Tag{stats.T("method", "GET")},
+		},
+		stats.Measure{
+			Name:   "request",
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+			Tags:   []stats.Tag{stats.T("method", "POST")},
+		},
+	)
+	h.Flush()
+
+	const expect = `2017-07-23T03:36:01Z  counter    request.count  3      method=GET
+2017-07-23T03:36:01Z  histogram  request.rtt    200ms  method=GET
+2017-07-23T03:36:00Z  gauge      goroutines     42
+2017-07-23T03:36:01Z  counter    request.count  1      method=POST
+`
+
+	if s := b.String(); s != expect {
+		t.Error("bad output:")
+		t.Log("expected:\n" + expect)
+		t.Log("found:\n" + s)
+	}
+
+	b.Reset()
+	h.HandleMeasures(timestamp, measures[1])
+	h.Flush()
+
+	// Only the metrics which changed since the last flush are written.
+	if s := b.String(); s != "2017-07-23T03:36:00Z  gauge  goroutines  42\n" {
+		t.Errorf("bad output after the second flush: %q", s)
+	}
+}
+
+func TestHandlerSampleRate(t *testing.T) {
+	b := &bytes.Buffer{}
+	h := NewHandlerWith(Config{Output: b})
+
+	h.HandleMeasures(timestamp,
+		stats.Measure{
+			Name:   "request",
+			Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		},
+		stats.Measure{
+			Name:       "request",
+			Fields:     []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+			SampleRate: 0.5,
+		},
+	)
+	h.Flush()
+
+	if s := b.String(); s != "2017-07-23T03:36:00Z  counter  request.count  3\n" {
+		t.Errorf("bad output: %q", s)
+	}
+}
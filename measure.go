@@ -20,6 +20,12 @@ type Measure struct {
 	Name   string
 	Fields []Field
 	Tags   []Tag
+
+	// SampleRate is the probability with which the measure was recorded, it
+	// is set by engines configured with a SampleRate. Zero means that the
+	// measure was not sampled. Handlers which support it scale the measure
+	// back up by the inverse of the rate.
+	SampleRate float64
 }
 
 // Clone creates and returns a deep copy of m. The original and returned values
@@ -27,9 +33,10 @@ type Measure struct {
 // for example).
 func (m Measure) Clone() Measure {
 	return Measure{
-		Name:   m.Name,
-		Fields: copyFields(m.Fields),
-		Tags:   copyTags(m.Tags),
+		Name:       m.Name,
+		Fields:     copyFields(m.Fields),
+		Tags:       copyTags(m.Tags),
+		SampleRate: m.SampleRate,
 	}
 }
 
@@ -66,7 +73,8 @@ func stringTags(tags []Tag) []string {
 //  int8, int16, int32, int64, uint, uint8, uint16, uint32, uint64, uintptr,
 //  float32, float64, or time.Duration, and represent fields of the measures.
 //  The struct fields may also define a 'type' tag with a value of "counter",
-//  "gauge" or "histogram" to tune the behavior of the measure handlers.
+//  "gauge", "histogram", "distribution" or "set" to tune the behavior of the
+//  measure handlers.
 //
 //  2. All fields exposing a 'tag' tag are expected to be of type string and
 //  represent tags of the measures.
@@ -381,6 +389,10 @@ func makeFieldType(mtype string) FieldType {
 		return Counter
 	case "gauge":
 		return Gauge
+	case "distribution":
+		return Distribution
+	case "set":
+		return SetType
 	default:
 		return Histogram
 	}
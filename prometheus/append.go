@@ -3,6 +3,7 @@ package prometheus
 import (
 	"strconv"
 	"strings"
+	"time"
 )
 
 func appendMetricName(b []byte, s string) []byte {
@@ -21,6 +22,17 @@ func appendMetricScopedName(b []byte, scope string, name string) []byte {
 }
 
 func appendMetric(b []byte, metric metric) []byte {
+	return appendMetricFormat(b, metric, false)
+}
+
+// appendOpenMetric is like appendMetric but produces the OpenMetrics
+// representation of the metric, which differs in the unit of timestamps and
+// supports exemplars.
+func appendOpenMetric(b []byte, metric metric) []byte {
+	return appendMetricFormat(b, metric, true)
+}
+
+func appendMetricFormat(b []byte, metric metric, openMetrics bool) []byte {
 	if len(metric.help) != 0 {
 		b = appendMetricHelp(b, metric.scope, metric.rootName(), metric.help)
 	}
@@ -35,15 +47,38 @@ func appendMetric(b []byte, metric metric) []byte {
 	b = strconv.AppendFloat(b, metric.value, 'g', -1, 64)
 
 	if !metric.time.IsZero() {
-		t := metric.time.Unix() * 1000
-		t += int64(metric.time.Nanosecond() / 1e6) // millisecond
 		b = append(b, ' ')
-		b = strconv.AppendInt(b, t, 10)
+		b = appendTimestamp(b, metric.time, openMetrics)
+	}
+
+	if openMetrics && len(metric.exemplar.traceID) != 0 {
+		b = append(b, " # "...)
+		b = appendLabels(b, label{name: "trace_id", value: metric.exemplar.traceID})
+		b = append(b, ' ')
+		b = strconv.AppendFloat(b, metric.exemplar.value, 'g', -1, 64)
+
+		if !metric.exemplar.time.IsZero() {
+			b = append(b, ' ')
+			b = appendTimestamp(b, metric.exemplar.time, true)
+		}
 	}
 
 	return append(b, '\n')
 }
 
+// appendTimestamp appends t as a number of milliseconds, or as a number of
+// seconds with a millisecond precision if openMetrics is true.
+func appendTimestamp(b []byte, t time.Time, openMetrics bool) []byte {
+	ms := t.Unix() * 1000
+	ms += int64(t.Nanosecond() / 1e6) // millisecond
+
+	if openMetrics {
+		return strconv.AppendFloat(b, float64(ms)/1000, 'f', 3, 64)
+	}
+
+	return strconv.AppendInt(b, ms, 10)
+}
+
 func appendMetricHelp(b []byte, scope string, name string, help string) []byte {
 	b = append(b, "# HELP "...)
 	b = appendMetricScopedName(b, scope, name)
@@ -0,0 +1,89 @@
+package prometheus
+
+import (
+	"context"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// TraceIDExtractor is the signature of functions used to extract the ID of the
+// sampled trace carried by a context. The function must return false if the
+// context carries no trace, or if the trace isn't sampled.
+type TraceIDExtractor func(context.Context) (traceID string, ok bool)
+
+// ObserveContext reports value for the histogram identified by name and tags
+// on eng, and records the trace ID extracted from ctx as exemplar of the
+// histogram bucket that value falls into. The handler keeps the latest
+// exemplar of each bucket, rendered when metrics are written in the
+// OpenMetrics format.
+//
+// The trace ID isn't carried by the measure, the other handlers of eng only
+// see a regular observation. The exemplar is recorded on h, which is expected
+// to be one of the handlers of eng.
+func (h *Handler) ObserveContext(ctx context.Context, eng *stats.Engine, extract TraceIDExtractor, name string, value interface{}, tags ...stats.Tag) {
+	traceID, ok := extract(ctx)
+	if !ok || len(traceID) == 0 {
+		eng.Observe(name, value, tags...)
+		return
+	}
+
+	// The observation goes through a copy of eng so the name and tags of the
+	// measure are the ones the handlers of eng receive.
+	(&stats.Engine{
+		Handler:    &exemplarHandler{handler: h, next: eng.Handler, traceID: traceID},
+		Prefix:     eng.Prefix,
+		Tags:       eng.Tags,
+		SampleRate: eng.SampleRate,
+	}).Observe(name, value, tags...)
+}
+
+type exemplar struct {
+	traceID string
+	value   float64
+	time    time.Time
+}
+
+// exemplarHandler forwards measures to the next handler, then records the
+// exemplars of the histograms they carry on the prometheus handler.
+type exemplarHandler struct {
+	handler *Handler
+	next    stats.Handler
+	traceID string
+}
+
+func (h *exemplarHandler) HandleMeasures(mtime time.Time, measures ...stats.Measure) {
+	if h.next != nil {
+		h.next.HandleMeasures(mtime, measures...)
+	}
+	h.handler.handleExemplars(h.traceID, measures...)
+}
+
+func (h *Handler) handleExemplars(traceID string, measures ...stats.Measure) {
+	var labels labels
+
+	for _, m := range measures {
+		scope := h.scope(m.Name)
+		labels = labels[:0].appendTags(m.Tags...)
+
+		for _, f := range m.Fields {
+			if typeOf(f.Type()) != histogram {
+				continue
+			}
+
+			value := valueOf(f.Value)
+
+			h.metrics.setExemplar(metric{
+				mtype:  histogram,
+				scope:  scope,
+				name:   f.Name,
+				value:  value,
+				labels: labels,
+				exemplar: exemplar{
+					traceID: traceID,
+					value:   value,
+				},
+			}, h.buckets(m.Name, f.Name))
+		}
+	}
+}
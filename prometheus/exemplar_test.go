@@ -0,0 +1,98 @@
+package prometheus
+
+import (
+	"context"
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+type testTraceKey struct{}
+
+func extractTestTraceID(ctx context.Context) (string, bool) {
+	traceID, ok := ctx.Value(testTraceKey{}).(string)
+	return traceID, ok
+}
+
+func TestExemplars(t *testing.T) {
+	now := time.Date(2017, 6, 4, 22, 12, 0, 0, time.UTC)
+
+	handler := &Handler{
+		Buckets: map[stats.Key][]stats.Value{
+			stats.Key{Measure: "request", Field: "rtt"}: []stats.Value{
+				stats.ValueOf(0.25),
+				stats.ValueOf(0.5),
+				stats.ValueOf(1.0),
+			},
+		},
+	}
+
+	// Measures seen by another handler of the engine, which must not carry
+	// the trace IDs.
+	var measures []stats.Measure
+
+	// Override the handler time to get a deterministic output.
+	eng := stats.NewEngine("", stats.MultiHandler(
+		stats.HandlerFunc(func(_ time.Time, m ...stats.Measure) {
+			handler.HandleMeasures(now, m...)
+		}),
+		stats.HandlerFunc(func(_ time.Time, m ...stats.Measure) {
+			for _, x := range m {
+				measures = append(measures, x.Clone())
+			}
+		}),
+	))
+
+	ctx := context.WithValue(context.Background(), testTraceKey{}, "abc123")
+
+	handler.ObserveContext(ctx, eng, extractTestTraceID, "request:rtt", 0.1)
+	handler.ObserveContext(ctx, eng, extractTestTraceID, "request:rtt", 0.4)
+	handler.ObserveContext(context.Background(), eng, extractTestTraceID, "request:rtt", 0.45)
+
+	if len(measures) != 3 {
+		t.Fatal("bad measure count:", len(measures))
+	}
+
+	for _, m := range measures {
+		if len(m.Tags) != 0 {
+			t.Error("trace ID leaked to other handlers:", m.Tags)
+		}
+	}
+
+	server := httptest.NewServer(handler)
+	defer server.Close()
+
+	req, _ := http.NewRequest("GET", server.URL, nil)
+	req.Header.Set("Accept", "application/openmetrics-text; version=1.0.0")
+
+	res, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer res.Body.Close()
+
+	if contentType := res.Header.Get("Content-Type"); contentType != "application/openmetrics-text; version=1.0.0; charset=utf-8" {
+		t.Error("bad content type:", contentType)
+	}
+
+	b, _ := ioutil.ReadAll(res.Body)
+
+	const expects = `# TYPE request_rtt histogram
+request_rtt_bucket{le="0.25"} 1 1496614320.000 # {trace_id="abc123"} 0.1 1496614320.000
+request_rtt_bucket{le="0.5"} 3 1496614320.000 # {trace_id="abc123"} 0.4 1496614320.000
+request_rtt_bucket{le="1"} 3 1496614320.000
+request_rtt_count 3 1496614320.000
+request_rtt_sum 0.95 1496614320.000
+# EOF
+`
+
+	if s := string(b); s != expects {
+		t.Error("bad output:")
+		t.Log("expected:", expects)
+		t.Log("found:", s)
+	}
+}
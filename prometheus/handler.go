@@ -35,6 +35,16 @@ type Handler struct {
 	// If empty, no prefix trimming is done.
 	TrimPrefix string
 
+	// Namespace and Subsystem are prepended to the names of all metrics
+	// exposed by the handler, joined by underscores, following the naming
+	// conventions of prometheus client libraries. They are applied after
+	// TrimPrefix, which allows the same engine to feed a statsd-like client
+	// and a prometheus endpoint with different naming schemes.
+	//
+	// If empty, no prefix is added.
+	Namespace string
+	Subsystem string
+
 	// MetricTimeout defines how long the handler exposes metrics that aren't
 	// receiving updates.
 	//
@@ -54,7 +64,7 @@ func (h *Handler) HandleMeasures(mtime time.Time, measures ...stats.Measure) {
 	cache := handleMetricPool.Get().(*handleMetricCache)
 
 	for _, m := range measures {
-		scope := h.trimPrefix(m.Name)
+		scope := h.scope(m.Name)
 
 		cache.labels = cache.labels[:0]
 		cache.labels = cache.labels.appendTags(m.Tags...)
@@ -64,22 +74,17 @@ func (h *Handler) HandleMeasures(mtime time.Time, measures ...stats.Measure) {
 			var mtype = typeOf(f.Type())
 
 			if mtype == histogram {
-				k := stats.Key{Measure: m.Name, Field: f.Name}
-
-				if b := h.Buckets; b != nil {
-					buckets = b[k]
-				} else {
-					buckets = stats.Buckets[k]
-				}
+				buckets = h.buckets(m.Name, f.Name)
 			}
 
 			h.metrics.update(metric{
-				mtype:  mtype,
-				scope:  scope,
-				name:   f.Name,
-				value:  valueOf(f.Value),
-				time:   mtime,
-				labels: cache.labels,
+				mtype:      mtype,
+				scope:      scope,
+				name:       f.Name,
+				value:      valueOf(f.Value),
+				time:       mtime,
+				labels:     cache.labels,
+				sampleRate: m.SampleRate,
 			}, buckets)
 		}
 
@@ -98,6 +103,24 @@ func (h *Handler) HandleMeasures(mtime time.Time, measures ...stats.Measure) {
 	}
 }
 
+func (h *Handler) scope(s string) string {
+	s = h.trimPrefix(s)
+	if len(h.Subsystem) != 0 {
+		s = joinScope(h.Subsystem, s)
+	}
+	if len(h.Namespace) != 0 {
+		s = joinScope(h.Namespace, s)
+	}
+	return s
+}
+
+func joinScope(prefix string, scope string) string {
+	if len(scope) == 0 {
+		return prefix
+	}
+	return prefix + "_" + scope
+}
+
 func (h *Handler) trimPrefix(s string) string {
 	s = strings.TrimPrefix(s, h.TrimPrefix)
 	if len(s) != 0 && s[0] == '.' {
@@ -106,6 +129,15 @@ func (h *Handler) trimPrefix(s string) string {
 	return s
 }
 
+func (h *Handler) buckets(measure, field string) []stats.Value {
+	k := stats.Key{Measure: measure, Field: field}
+
+	if b := h.Buckets; b != nil {
+		return b[k]
+	}
+	return stats.Buckets[k]
+}
+
 func (h *Handler) timeout() time.Duration {
 	if timeout := h.MetricTimeout; timeout != 0 {
 		return timeout
@@ -123,7 +155,13 @@ func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 	}
 
 	w := io.Writer(res)
-	res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	openMetrics := acceptOpenMetrics(req.Header.Get("Accept"))
+
+	if openMetrics {
+		res.Header().Set("Content-Type", "application/openmetrics-text; version=1.0.0; charset=utf-8")
+	} else {
+		res.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	}
 
 	if acceptEncoding(req.Header.Get("Accept-Encoding"), "gzip") {
 		res.Header().Set("Content-Encoding", "gzip")
@@ -132,13 +170,28 @@ func (h *Handler) ServeHTTP(res http.ResponseWriter, req *http.Request) {
 		w = zw
 	}
 
-	h.WriteStats(w)
+	if openMetrics {
+		h.WriteOpenMetrics(w)
+	} else {
+		h.WriteStats(w)
+	}
 }
 
 // WriteStats accepts a writer and pushes metrics (one at a time) to it.
 // An example could be if you just want to print all the metrics on to Stdout
 // It will not call flush. Make sure the Close and Flush are handled at the caller
 func (h *Handler) WriteStats(w io.Writer) {
+	h.writeStats(w, appendMetric)
+}
+
+// WriteOpenMetrics is like WriteStats but writes the metrics in the
+// OpenMetrics format, which includes the exemplars of histogram buckets.
+func (h *Handler) WriteOpenMetrics(w io.Writer) {
+	h.writeStats(w, appendOpenMetric)
+	io.WriteString(w, "# EOF\n")
+}
+
+func (h *Handler) writeStats(w io.Writer, appendMetric func([]byte, metric) []byte) {
 	b := make([]byte, 1024)
 
 	var lastMetricName string
@@ -164,6 +217,15 @@ func (h *Handler) WriteStats(w io.Writer) {
 	}
 }
 
+func acceptOpenMetrics(accept string) bool {
+	for _, mediaType := range strings.Split(accept, ",") {
+		if mediaType = strings.TrimSpace(mediaType); strings.HasPrefix(mediaType, "application/openmetrics-text") {
+			return true
+		}
+	}
+	return false
+}
+
 func acceptEncoding(accept string, check string) bool {
 	for _, coding := range strings.Split(accept, ",") {
 		if coding = strings.TrimSpace(coding); strings.HasPrefix(coding, check) {
@@ -207,7 +269,7 @@ func typeOf(t stats.FieldType) metricType {
 		return counter
 	case stats.Gauge:
 		return gauge
-	case stats.Histogram:
+	case stats.Histogram, stats.Distribution:
 		return histogram
 	default:
 		return untyped
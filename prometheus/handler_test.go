@@ -1,6 +1,7 @@
 package prometheus
 
 import (
+	"bytes"
 	"fmt"
 	"io/ioutil"
 	"net/http"
@@ -118,6 +119,87 @@ C_sum 10.7 1496614320000
 	}
 }
 
+func TestHandlerNamespace(t *testing.T) {
+	now := time.Date(2017, 6, 4, 22, 12, 0, 0, time.UTC)
+
+	tests := []struct {
+		scenario string
+		handler  *Handler
+		expects  string
+	}{
+		{
+			scenario: "namespace and subsystem",
+			handler:  &Handler{Namespace: "app", Subsystem: "http"},
+			expects:  "# TYPE app_http_requests_count counter\napp_http_requests_count 1 1496614320000\n",
+		},
+		{
+			scenario: "namespace only",
+			handler:  &Handler{Namespace: "app"},
+			expects:  "# TYPE app_requests_count counter\napp_requests_count 1 1496614320000\n",
+		},
+		{
+			scenario: "namespace with trimmed prefix",
+			handler:  &Handler{Namespace: "app", TrimPrefix: "requests"},
+			expects:  "# TYPE app_count counter\napp_count 1 1496614320000\n",
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.scenario, func(t *testing.T) {
+			test.handler.HandleMeasures(now, stats.Measure{
+				Name:   "requests",
+				Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+			})
+
+			b := &bytes.Buffer{}
+			test.handler.WriteStats(b)
+
+			if s := b.String(); s != test.expects {
+				t.Error("bad output:")
+				t.Log("expected:", test.expects)
+				t.Log("found:", s)
+			}
+		})
+	}
+}
+
+func TestHandlerSampleRate(t *testing.T) {
+	now := time.Date(2017, 6, 4, 22, 12, 0, 0, time.UTC)
+
+	handler := &Handler{
+		Buckets: map[stats.Key][]stats.Value{
+			stats.Key{Field: "C"}: []stats.Value{stats.ValueOf(1.0)},
+		},
+	}
+
+	handler.HandleMeasures(now,
+		stats.Measure{Fields: []stats.Field{stats.MakeField("A", 1, stats.Counter)}, SampleRate: 0.25},
+		stats.Measure{Fields: []stats.Field{stats.MakeField("B", 3, stats.Gauge)}, SampleRate: 0.25},
+		stats.Measure{Fields: []stats.Field{stats.MakeField("C", 0.5, stats.Histogram)}, SampleRate: 0.5},
+	)
+
+	b := &bytes.Buffer{}
+	handler.WriteStats(b)
+
+	const expects = `# TYPE A counter
+A 4 1496614320000
+
+# TYPE B gauge
+B 3 1496614320000
+
+# TYPE C histogram
+C_bucket{le="1"} 2 1496614320000
+C_count 2 1496614320000
+C_sum 1 1496614320000
+`
+
+	if s := b.String(); s != expects {
+		t.Error("bad output:")
+		t.Log("expected:", expects)
+		t.Log("found:", s)
+	}
+}
+
 func BenchmarkHandleMetric(b *testing.B) {
 	now := time.Now()
 
@@ -1,12 +1,10 @@
 package prometheus
 
 import (
-	"reflect"
 	"strconv"
 	"strings"
 	"sync"
 	"time"
-	"unsafe"
 
 	"github.com/segmentio/stats"
 )
@@ -44,19 +42,32 @@ type metricKey struct {
 }
 
 type metric struct {
-	mtype  metricType
-	scope  string
-	name   string
-	help   string
-	value  float64
-	time   time.Time
-	labels labels
+	mtype    metricType
+	scope    string
+	name     string
+	help     string
+	value    float64
+	time     time.Time
+	labels   labels
+	exemplar exemplar
+
+	// Sample rate of the measure that the metric was produced from, zero
+	// when it was not sampled.
+	sampleRate float64
 }
 
 func (m metric) key() metricKey {
 	return metricKey{scope: m.scope, name: m.name}
 }
 
+// weight returns the number of observations that the metric stands for.
+func (m metric) weight() float64 {
+	if m.sampleRate > 0 && m.sampleRate < 1 {
+		return 1 / m.sampleRate
+	}
+	return 1
+}
+
 func (m metric) rootName() string {
 	if m.mtype == histogram {
 		return m.name[:strings.LastIndexByte(m.name, '_')]
@@ -98,7 +109,13 @@ func (store *metricStore) lookup(mtype metricType, key metricKey, help string) *
 func (store *metricStore) update(metric metric, buckets []stats.Value) {
 	entry := store.lookup(metric.mtype, metric.key(), metric.help)
 	state := entry.lookup(metric.labels)
-	state.update(metric.mtype, metric.value, metric.time, buckets)
+	state.update(metric.mtype, metric.value, metric.weight(), metric.time, buckets)
+}
+
+func (store *metricStore) setExemplar(metric metric, buckets []stats.Value) {
+	entry := store.lookup(metric.mtype, metric.key(), metric.help)
+	state := entry.lookup(metric.labels)
+	state.setExemplar(metric.exemplar, buckets)
 }
 
 func (store *metricStore) collect(metrics []metric) []metric {
@@ -242,7 +259,7 @@ type metricState struct {
 	buckets metricBuckets
 	value   float64
 	sum     float64
-	count   uint64
+	count   float64
 	time    time.Time
 }
 
@@ -252,12 +269,12 @@ func newMetricState(labels labels) *metricState {
 	}
 }
 
-func (state *metricState) update(mtype metricType, value float64, time time.Time, buckets []stats.Value) {
+func (state *metricState) update(mtype metricType, value float64, weight float64, time time.Time, buckets []stats.Value) {
 	state.mutex.Lock()
 
 	switch mtype {
 	case counter:
-		state.value += value
+		state.value += value * weight
 
 	case gauge:
 		state.value = value
@@ -266,15 +283,29 @@ func (state *metricState) update(mtype metricType, value float64, time time.Time
 		if len(state.buckets) != len(buckets) {
 			state.buckets = makeMetricBuckets(buckets, state.labels)
 		}
-		state.buckets.update(value)
-		state.sum += value
-		state.count++
+		state.buckets.update(value, weight)
+		state.sum += value * weight
+		state.count += weight
 	}
 
 	state.time = time
 	state.mutex.Unlock()
 }
 
+func (state *metricState) setExemplar(exemplar exemplar, buckets []stats.Value) {
+	state.mutex.Lock()
+
+	if len(state.buckets) != len(buckets) {
+		state.buckets = makeMetricBuckets(buckets, state.labels)
+	}
+
+	// The exemplar is recorded after the observation it belongs to, so it
+	// shares the time of the latest update of the histogram.
+	exemplar.time = state.time
+	state.buckets.setExemplar(exemplar)
+	state.mutex.Unlock()
+}
+
 func (state *metricState) collect(metrics []metric, entry *metricEntry) []metric {
 	state.mutex.Lock()
 
@@ -294,17 +325,18 @@ func (state *metricState) collect(metrics []metric, entry *metricEntry) []metric
 		// Prometheus' scraper expects for histogram buckets to be cumulative.
 		// [1] https://prometheus.io/docs/practices/histograms/#apdex-score
 		// [2] https://en.wikipedia.org/wiki/Histogram#Cumulative_histogram
-		var cumulativeCount uint64
+		var cumulativeCount float64
 		for _, bucket := range state.buckets {
 			cumulativeCount += bucket.count
 			metrics = append(metrics, metric{
-				mtype:  entry.mtype,
-				scope:  entry.scope,
-				name:   entry.bucket,
-				help:   entry.help,
-				value:  float64(cumulativeCount),
-				time:   state.time,
-				labels: bucket.labels,
+				mtype:    entry.mtype,
+				scope:    entry.scope,
+				name:     entry.bucket,
+				help:     entry.help,
+				value:    cumulativeCount,
+				time:     state.time,
+				labels:   bucket.labels,
+				exemplar: bucket.exemplar,
 			})
 		}
 		metrics = append(metrics,
@@ -322,7 +354,7 @@ func (state *metricState) collect(metrics []metric, entry *metricEntry) []metric
 				scope:  entry.scope,
 				name:   entry.count,
 				help:   entry.help,
-				value:  float64(state.count),
+				value:  state.count,
 				time:   state.time,
 				labels: state.labels,
 			},
@@ -352,9 +384,10 @@ func (m metricStateMap) find(key uint64, labels labels) *metricState {
 }
 
 type metricBucket struct {
-	limit  float64
-	count  uint64
-	labels labels
+	limit    float64
+	count    float64
+	labels   labels
+	exemplar exemplar
 }
 
 type metricBuckets []metricBucket
@@ -373,10 +406,19 @@ func makeMetricBuckets(buckets []stats.Value, labels labels) metricBuckets {
 	return b
 }
 
-func (m metricBuckets) update(value float64) {
+func (m metricBuckets) update(value float64, weight float64) {
 	for i := range m {
 		if value <= m[i].limit {
-			m[i].count++
+			m[i].count += weight
+			break
+		}
+	}
+}
+
+func (m metricBuckets) setExemplar(exemplar exemplar) {
+	for i := range m {
+		if exemplar.value <= m[i].limit {
+			m[i].exemplar = exemplar
 			break
 		}
 	}
@@ -402,10 +444,7 @@ func le(buckets []stats.Value) string {
 		b = appendFloat(b, valueOf(v))
 	}
 
-	return *(*string)(unsafe.Pointer(&reflect.StringHeader{
-		Data: uintptr(unsafe.Pointer(&b[0])),
-		Len:  len(b),
-	}))
+	return string(b)
 }
 
 func nextLe(s string) (head string, tail string) {
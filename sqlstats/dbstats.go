@@ -0,0 +1,69 @@
+package sqlstats
+
+import (
+	"database/sql"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// DBStats is a collector of the connection pool metrics of a sql.DB, it can be
+// started with procstats.StartCollector to report them periodically.
+type DBStats struct {
+	engine *stats.Engine
+	db     *sql.DB
+	last   sql.DBStats
+
+	conns struct {
+		max   int `metric:"max"    type:"gauge"`
+		open  int `metric:"open"   type:"gauge"`
+		inUse int `metric:"in_use" type:"gauge"`
+		idle  int `metric:"idle"   type:"gauge"`
+	} `metric:"sql.conns"`
+
+	wait struct {
+		count    int64         `metric:"count"    type:"counter"`
+		duration time.Duration `metric:"duration" type:"counter"`
+	} `metric:"sql.conns.wait"`
+
+	closed struct {
+		maxIdle     int64 `metric:"max_idle"      type:"counter"`
+		maxIdleTime int64 `metric:"max_idle_time" type:"counter"`
+		maxLifetime int64 `metric:"max_lifetime"  type:"counter"`
+	} `metric:"sql.conns.closed"`
+}
+
+// NewDBStats creates a new collector for the connection pool of db that
+// produces metrics on the default stats engine.
+func NewDBStats(db *sql.DB) *DBStats {
+	return NewDBStatsWith(stats.DefaultEngine, db)
+}
+
+// NewDBStatsWith creates a new collector for the connection pool of db that
+// produces metrics on eng.
+func NewDBStatsWith(eng *stats.Engine, db *sql.DB) *DBStats {
+	return &DBStats{
+		engine: eng,
+		db:     db,
+	}
+}
+
+// Collect satisfies the procstats.Collector interface.
+func (d *DBStats) Collect() {
+	s := d.db.Stats()
+
+	d.conns.max = s.MaxOpenConnections
+	d.conns.open = s.OpenConnections
+	d.conns.inUse = s.InUse
+	d.conns.idle = s.Idle
+
+	d.wait.count = s.WaitCount - d.last.WaitCount
+	d.wait.duration = s.WaitDuration - d.last.WaitDuration
+
+	d.closed.maxIdle = s.MaxIdleClosed - d.last.MaxIdleClosed
+	d.closed.maxIdleTime = s.MaxIdleTimeClosed - d.last.MaxIdleTimeClosed
+	d.closed.maxLifetime = s.MaxLifetimeClosed - d.last.MaxLifetimeClosed
+
+	d.last = s
+	d.engine.Report(d)
+}
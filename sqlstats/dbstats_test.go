@@ -0,0 +1,37 @@
+package sqlstats
+
+import (
+	"testing"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/statstest"
+)
+
+func TestDBStats(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	if err := db.Ping(); err != nil {
+		t.Fatal(err)
+	}
+
+	h := &statstest.Handler{}
+	NewDBStatsWith(stats.NewEngine("", h), db).Collect()
+
+	values := map[string]int64{}
+	for _, m := range h.Measures() {
+		for _, f := range m.Fields {
+			values[m.Name+"."+f.Name] = f.Value.Int()
+		}
+	}
+
+	if n := values["sql.conns.open"]; n != 1 {
+		t.Errorf("bad number of open connections: %d", n)
+	}
+	if n := values["sql.conns.idle"]; n != 1 {
+		t.Errorf("bad number of idle connections: %d", n)
+	}
+	if _, ok := values["sql.conns.wait.count"]; !ok {
+		t.Errorf("missing wait count in %v", values)
+	}
+}
@@ -0,0 +1,280 @@
+package sqlstats
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"time"
+
+	"github.com/segmentio/stats"
+)
+
+// NewDriver wraps d to produce metrics on the default engine for every query,
+// statement, and transaction executed through the connections it opens.
+//
+// The returned driver must be registered with sql.Register before being used
+// by sql.Open, for example:
+//
+//	sql.Register("stats-postgres", sqlstats.NewDriver(&pq.Driver{}))
+func NewDriver(d driver.Driver) driver.Driver {
+	return NewDriverWith(stats.DefaultEngine, d)
+}
+
+// NewDriverWith wraps d to produce metrics on eng for every query, statement,
+// and transaction executed through the connections it opens.
+func NewDriverWith(eng *stats.Engine, d driver.Driver) driver.Driver {
+	return &sqlDriver{
+		driver: d,
+		eng:    eng,
+	}
+}
+
+type queryNameKey struct{}
+
+// WithQueryName returns a copy of ctx carrying name, which is added as a query
+// tag on the metrics of the operations executed with the returned context.
+//
+// The name should be taken from a small set of static values, such as the
+// function issuing the query, using the query text would create too many
+// distinct series.
+func WithQueryName(ctx context.Context, name string) context.Context {
+	return context.WithValue(ctx, queryNameKey{}, name)
+}
+
+type sqlDriver struct {
+	driver driver.Driver
+	eng    *stats.Engine
+}
+
+func (d *sqlDriver) Open(name string) (driver.Conn, error) {
+	start := time.Now()
+	c, err := d.driver.Open(name)
+	d.observe(context.Background(), "sql.conn", "open", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlConn{conn: c, driver: d}, nil
+}
+
+// observe reports the count, latency, and errors of operation op which started
+// at start, under the sql.conn, sql.query, or sql.transaction metrics.
+func (d *sqlDriver) observe(ctx context.Context, metric string, op string, start time.Time, err error) {
+	if err == driver.ErrSkip {
+		// The operation was not supported by the driver, database/sql falls
+		// back to another method which is measured instead.
+		return
+	}
+
+	rtt := time.Now().Sub(start)
+	tags := make([]stats.Tag, 1, 2)
+	tags[0] = stats.T("operation", op)
+
+	if name, ok := ctx.Value(queryNameKey{}).(string); ok {
+		tags = append(tags, stats.T("query", name))
+	}
+
+	d.eng.Incr(metric+".count", tags...)
+	d.eng.Observe(metric+".rtt", rtt, tags...)
+
+	if err != nil {
+		d.eng.Incr(metric+".error.count", tags...)
+	}
+}
+
+type sqlConn struct {
+	conn   driver.Conn
+	driver *sqlDriver
+}
+
+func (c *sqlConn) Prepare(query string) (driver.Stmt, error) {
+	return c.PrepareContext(context.Background(), query)
+}
+
+func (c *sqlConn) PrepareContext(ctx context.Context, query string) (stmt driver.Stmt, err error) {
+	start := time.Now()
+
+	if p, ok := c.conn.(driver.ConnPrepareContext); ok {
+		stmt, err = p.PrepareContext(ctx, query)
+	} else {
+		stmt, err = c.conn.Prepare(query)
+	}
+
+	c.driver.observe(ctx, "sql.query", "prepare", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlStmt{stmt: stmt, driver: c.driver}, nil
+}
+
+func (c *sqlConn) Close() error {
+	return c.conn.Close()
+}
+
+func (c *sqlConn) Begin() (driver.Tx, error) {
+	return c.BeginTx(context.Background(), driver.TxOptions{})
+}
+
+func (c *sqlConn) BeginTx(ctx context.Context, opts driver.TxOptions) (tx driver.Tx, err error) {
+	start := time.Now()
+
+	if b, ok := c.conn.(driver.ConnBeginTx); ok {
+		tx, err = b.BeginTx(ctx, opts)
+	} else if err = checkTxOptions(opts); err == nil {
+		tx, err = c.conn.Begin()
+	}
+
+	c.driver.observe(ctx, "sql.query", "begin", start, err)
+	if err != nil {
+		return nil, err
+	}
+	return &sqlTx{tx: tx, driver: c.driver, ctx: ctx, start: start}, nil
+}
+
+func (c *sqlConn) ExecContext(ctx context.Context, query string, args []driver.NamedValue) (res driver.Result, err error) {
+	e, ok := c.conn.(driver.ExecerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	res, err = e.ExecContext(ctx, query, args)
+	c.driver.observe(ctx, "sql.query", "exec", start, err)
+	return
+}
+
+func (c *sqlConn) QueryContext(ctx context.Context, query string, args []driver.NamedValue) (rows driver.Rows, err error) {
+	q, ok := c.conn.(driver.QueryerContext)
+	if !ok {
+		return nil, driver.ErrSkip
+	}
+	start := time.Now()
+	rows, err = q.QueryContext(ctx, query, args)
+	c.driver.observe(ctx, "sql.query", "query", start, err)
+	return
+}
+
+func (c *sqlConn) Ping(ctx context.Context) error {
+	if p, ok := c.conn.(driver.Pinger); ok {
+		return p.Ping(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) ResetSession(ctx context.Context) error {
+	if r, ok := c.conn.(driver.SessionResetter); ok {
+		return r.ResetSession(ctx)
+	}
+	return nil
+}
+
+func (c *sqlConn) CheckNamedValue(v *driver.NamedValue) error {
+	if n, ok := c.conn.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+type sqlStmt struct {
+	stmt   driver.Stmt
+	driver *sqlDriver
+}
+
+func (s *sqlStmt) Close() error {
+	return s.stmt.Close()
+}
+
+func (s *sqlStmt) NumInput() int {
+	return s.stmt.NumInput()
+}
+
+func (s *sqlStmt) Exec(args []driver.Value) (driver.Result, error) {
+	return s.ExecContext(context.Background(), namedValues(args))
+}
+
+func (s *sqlStmt) Query(args []driver.Value) (driver.Rows, error) {
+	return s.QueryContext(context.Background(), namedValues(args))
+}
+
+func (s *sqlStmt) ExecContext(ctx context.Context, args []driver.NamedValue) (res driver.Result, err error) {
+	start := time.Now()
+
+	if e, ok := s.stmt.(driver.StmtExecContext); ok {
+		res, err = e.ExecContext(ctx, args)
+	} else {
+		res, err = s.stmt.Exec(values(args))
+	}
+
+	s.driver.observe(ctx, "sql.query", "exec", start, err)
+	return
+}
+
+func (s *sqlStmt) QueryContext(ctx context.Context, args []driver.NamedValue) (rows driver.Rows, err error) {
+	start := time.Now()
+
+	if q, ok := s.stmt.(driver.StmtQueryContext); ok {
+		rows, err = q.QueryContext(ctx, args)
+	} else {
+		rows, err = s.stmt.Query(values(args))
+	}
+
+	s.driver.observe(ctx, "sql.query", "query", start, err)
+	return
+}
+
+func (s *sqlStmt) CheckNamedValue(v *driver.NamedValue) error {
+	if n, ok := s.stmt.(driver.NamedValueChecker); ok {
+		return n.CheckNamedValue(v)
+	}
+	return driver.ErrSkip
+}
+
+type sqlTx struct {
+	tx     driver.Tx
+	driver *sqlDriver
+	ctx    context.Context
+	start  time.Time
+}
+
+func (t *sqlTx) Commit() error {
+	return t.end("commit", t.tx.Commit())
+}
+
+func (t *sqlTx) Rollback() error {
+	return t.end("rollback", t.tx.Rollback())
+}
+
+// end reports the operation which ended the transaction, its latency covers the
+// whole transaction.
+func (t *sqlTx) end(op string, err error) error {
+	t.driver.observe(t.ctx, "sql.transaction", op, t.start, err)
+	return err
+}
+
+// checkTxOptions returns an error if opts cannot be honored by a driver which
+// doesn't implement driver.ConnBeginTx, database/sql would return the same
+// errors if the driver wasn't wrapped.
+func checkTxOptions(opts driver.TxOptions) error {
+	if opts.Isolation != driver.IsolationLevel(sql.LevelDefault) {
+		return errors.New("sql: driver does not support non-default isolation level")
+	}
+	if opts.ReadOnly {
+		return errors.New("sql: driver does not support read-only transactions")
+	}
+	return nil
+}
+
+func namedValues(args []driver.Value) []driver.NamedValue {
+	named := make([]driver.NamedValue, len(args))
+	for i, v := range args {
+		named[i] = driver.NamedValue{Ordinal: i + 1, Value: v}
+	}
+	return named
+}
+
+func values(args []driver.NamedValue) []driver.Value {
+	v := make([]driver.Value, len(args))
+	for i, a := range args {
+		v[i] = a.Value
+	}
+	return v
+}
@@ -0,0 +1,164 @@
+package sqlstats
+
+import (
+	"context"
+	"database/sql"
+	"database/sql/driver"
+	"errors"
+	"io"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/segmentio/stats"
+	"github.com/segmentio/stats/statstest"
+)
+
+var (
+	registerOnce sync.Once
+	testHandler  = &statstest.Handler{}
+)
+
+func openTestDB(t *testing.T) *sql.DB {
+	registerOnce.Do(func() {
+		sql.Register("sqlstats-test", NewDriverWith(stats.NewEngine("", testHandler), &testDriver{}))
+	})
+	testHandler.Clear()
+
+	db, err := sql.Open("sqlstats-test", "")
+	if err != nil {
+		t.Fatal(err)
+	}
+	return db
+}
+
+// counts returns the name and tags of the counters reported to the handler.
+func counts(h *statstest.Handler) []string {
+	var found []string
+
+	for _, m := range h.Measures() {
+		if !strings.HasSuffix(m.Name, ".count") {
+			continue
+		}
+		s := m.Name
+		for _, t := range m.Tags {
+			s += " " + t.Name + ":" + t.Value
+		}
+		found = append(found, s)
+	}
+
+	return found
+}
+
+func TestDriver(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	ctx := WithQueryName(context.Background(), "insert_user")
+
+	if _, err := db.ExecContext(ctx, "INSERT"); err != nil {
+		t.Fatal(err)
+	}
+
+	rows, err := db.Query("SELECT")
+	if err != nil {
+		t.Fatal(err)
+	}
+	rows.Close()
+
+	tx, err := db.Begin()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := tx.Commit(); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, err := db.Exec("FAIL"); err == nil {
+		t.Error("expected an error")
+	}
+
+	expect := []string{
+		"sql.conn.count operation:open",
+		"sql.query.count operation:prepare query:insert_user",
+		"sql.query.count operation:exec query:insert_user",
+		"sql.query.count operation:prepare",
+		"sql.query.count operation:query",
+		"sql.query.count operation:begin",
+		"sql.transaction.count operation:commit",
+		"sql.query.count operation:prepare",
+		"sql.query.count operation:exec",
+		"sql.query.error.count operation:exec",
+	}
+
+	if found := counts(testHandler); !reflect.DeepEqual(found, expect) {
+		t.Errorf("bad metrics:\nwant: %q\ngot:  %q", expect, found)
+	}
+}
+
+func TestDriverTxOptions(t *testing.T) {
+	db := openTestDB(t)
+	defer db.Close()
+
+	for _, opts := range []*sql.TxOptions{
+		{Isolation: sql.LevelSerializable},
+		{ReadOnly: true},
+	} {
+		if tx, err := db.BeginTx(context.Background(), opts); err == nil {
+			tx.Rollback()
+			t.Errorf("no error returned for unsupported transaction options: %+v", opts)
+		}
+	}
+
+	expect := []string{
+		"sql.conn.count operation:open",
+		"sql.query.count operation:begin",
+		"sql.query.error.count operation:begin",
+		"sql.query.count operation:begin",
+		"sql.query.error.count operation:begin",
+	}
+
+	if found := counts(testHandler); !reflect.DeepEqual(found, expect) {
+		t.Errorf("bad metrics:\nwant: %q\ngot:  %q", expect, found)
+	}
+}
+
+// testDriver is a driver which only implements the mandatory interfaces,
+// statements fail when the query is FAIL.
+type testDriver struct{}
+
+func (*testDriver) Open(string) (driver.Conn, error) { return &testConn{}, nil }
+
+type testConn struct{}
+
+func (*testConn) Prepare(query string) (driver.Stmt, error) { return &testStmt{query: query}, nil }
+func (*testConn) Close() error                              { return nil }
+func (*testConn) Begin() (driver.Tx, error)                 { return &testTx{}, nil }
+
+type testStmt struct{ query string }
+
+func (*testStmt) Close() error  { return nil }
+func (*testStmt) NumInput() int { return 0 }
+
+func (s *testStmt) Exec([]driver.Value) (driver.Result, error) {
+	if s.query == "FAIL" {
+		return nil, errors.New("failed")
+	}
+	return driver.RowsAffected(1), nil
+}
+
+func (s *testStmt) Query([]driver.Value) (driver.Rows, error) {
+	return &testRows{}, nil
+}
+
+type testTx struct{}
+
+func (*testTx) Commit() error   { return nil }
+func (*testTx) Rollback() error { return nil }
+
+type testRows struct{}
+
+func (*testRows) Columns() []string         { return nil }
+func (*testRows) Close() error              { return nil }
+func (*testRows) Next([]driver.Value) error { return io.EOF }
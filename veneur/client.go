@@ -94,10 +94,13 @@ func (c *Client) HandleMeasures(time time.Time, measures ...stats.Measure) {
 		return
 	}
 
+	// The tags are appended to the cloned measures, appending to the original
+	// tag slices would write to arrays owned by the engine that produced the
+	// measures, which may be reused concurrently.
 	finalMeasures := make([]stats.Measure, len(measures))
-	for i, _ := range measures {
+	for i := range measures {
 		finalMeasures[i] = measures[i].Clone()
-		finalMeasures[i].Tags = append(measures[i].Tags, c.tags...)
+		finalMeasures[i].Tags = append(finalMeasures[i].Tags, c.tags...)
 	}
 
 	c.Client.HandleMeasures(time, finalMeasures...)
@@ -1,6 +1,7 @@
 package veneur
 
 import (
+	"sync"
 	"testing"
 	"time"
 
@@ -30,3 +31,50 @@ func TestClient(t *testing.T) {
 		t.Error(err)
 	}
 }
+
+func TestClientDoesNotModifyMeasures(t *testing.T) {
+	client := NewClientWith(ClientConfig{GlobalOnly: true})
+	defer client.Close()
+
+	tags := make([]stats.Tag, 1, 2)
+	tags[0] = stats.T("hello", "world")
+
+	client.HandleMeasures(time.Time{}, stats.Measure{
+		Name:   "request",
+		Fields: []stats.Field{stats.MakeField("count", 1, stats.Counter)},
+		Tags:   tags,
+	})
+
+	if tag := tags[:2][1]; tag != (stats.Tag{}) {
+		t.Error("the client wrote to the spare capacity of the measure tags:", tag)
+	}
+}
+
+func TestClientConcurrentEngineWrites(t *testing.T) {
+	client := NewClientWith(ClientConfig{GlobalOnly: true})
+	defer client.Close()
+
+	engine := stats.NewEngine("veneur.test", client, stats.T("service", "test"))
+	wg := sync.WaitGroup{}
+
+	for i := 0; i != 4; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for j := 0; j != 1000; j++ {
+				engine.Incr("A", stats.T("id", "1"))
+				engine.Observe("B", j)
+			}
+		}()
+	}
+
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		for j := 0; j != 100; j++ {
+			client.Flush()
+		}
+	}()
+
+	wg.Wait()
+}